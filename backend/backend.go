@@ -22,6 +22,24 @@
 // Package backend provides an image storage backend interface.
 package backend
 
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFound is returned for files which do not exist.
+	ErrNotFound = errors.New("backend: file not found")
+	// ErrForbidden is returned for files the backend may not access.
+	ErrForbidden = errors.New("backend: access denied")
+	// ErrUnavailable is returned if the backend fails or cannot be
+	// reached. Such errors are transient, a retry may succeed.
+	ErrUnavailable = errors.New("backend: unavailable")
+	// ErrTooLarge is returned for files exceeding a backend size limit.
+	ErrTooLarge = errors.New("backend: file too large")
+)
+
 type ImageBackend interface {
 	// ReadFile reads the file named by filename and returns the contents.
 	// A successful call returns err == nil, not err == EOF. Because ReadFile
@@ -29,3 +47,25 @@ type ImageBackend interface {
 	// to be reported.
 	ReadFile(filename string) ([]byte, error)
 }
+
+// FileInfo describes a file in a backend.
+type FileInfo struct {
+	Size    int64
+	ModTime time.Time
+	// ETag identifies the file version. It is empty if unknown.
+	ETag string
+	// ContentType is the MIME type of the file. It is empty if unknown.
+	ContentType string
+}
+
+// StatBackend is implemented by backends which can describe a file without
+// reading it and stream its contents.
+type StatBackend interface {
+	ImageBackend
+
+	// Stat returns a FileInfo describing the file named by filename.
+	Stat(filename string) (*FileInfo, error)
+
+	// Open opens the file named by filename for reading.
+	Open(filename string) (io.ReadCloser, error)
+}
@@ -1,8 +1,11 @@
 package backend
 
 import (
-	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"os"
 	"path"
 	"path/filepath"
 	"strings"
@@ -11,9 +14,9 @@ import (
 // Dir implementation the ImageBackend
 type Dir string
 
-func (d Dir) ReadFile(name string) ([]byte, error) {
+func (d Dir) path(name string) (string, error) {
 	if filepath.Separator != '/' && strings.IndexRune(name, filepath.Separator) >= 0 || strings.Contains(name, "\x00") {
-		return nil, errors.New("http: invalid character in file path")
+		return "", fmt.Errorf("%w: invalid character in file path", ErrNotFound)
 	}
 
 	dir := string(d)
@@ -22,5 +25,75 @@ func (d Dir) ReadFile(name string) ([]byte, error) {
 		dir = "."
 	}
 
-	return ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))))
+	return filepath.Join(dir, filepath.FromSlash(path.Clean("/"+name))), nil
+}
+
+func (d Dir) ReadFile(name string) ([]byte, error) {
+	filename, err := d.path(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(filename)
+
+	if err != nil {
+		return nil, dirError(err)
+	}
+
+	return data, nil
+}
+
+// Stat derives the ETag from the modification time and size of the file.
+func (d Dir) Stat(name string) (*FileInfo, error) {
+	filename, err := d.path(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := os.Stat(filename)
+
+	if err != nil {
+		return nil, dirError(err)
+	}
+
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%w: %s is a directory", ErrNotFound, name)
+	}
+
+	return &FileInfo{
+		Size:        fi.Size(),
+		ModTime:     fi.ModTime(),
+		ETag:        fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+	}, nil
+}
+
+func (d Dir) Open(name string) (io.ReadCloser, error) {
+	filename, err := d.path(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+
+	if err != nil {
+		return nil, dirError(err)
+	}
+
+	return f, nil
+}
+
+// dirError wraps file system errors with the backend errors.
+func dirError(err error) error {
+	switch {
+	case os.IsNotExist(err):
+		return fmt.Errorf("%w: %v", ErrNotFound, err)
+	case os.IsPermission(err):
+		return fmt.Errorf("%w: %v", ErrForbidden, err)
+	}
+
+	return err
 }
@@ -0,0 +1,55 @@
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	d := Dir("../image/fixture")
+	data, err := d.ReadFile("circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := d.Stat("/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size != int64(len(data)) || fi.ModTime.IsZero() || fi.ETag == "" || fi.ContentType != "image/png" {
+		t.Fatalf("unexpected file info %+v", fi)
+	}
+
+	rc, err := d.Open("circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rc.Close()
+	streamed, err := ioutil.ReadAll(rc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(streamed) != string(data) {
+		t.Fatal("expected streamed data to equal ReadFile data")
+	}
+
+	if _, err := d.Stat("missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := d.Open("missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := d.Stat("."); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for directory, got %v", err)
+	}
+}
@@ -0,0 +1,271 @@
+package backend
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configures an HTTP backend.
+type HTTPOptions struct {
+	// BaseURL is the URL file paths are resolved against.
+	BaseURL string
+	// AllowedHosts are the hosts which may be fetched when file paths are
+	// full http or https URLs. It is only used if BaseURL is empty.
+	AllowedHosts []string
+	// Timeout limits the time of a request. Zero means no timeout.
+	Timeout time.Duration
+	// MaxBytes limits the size of a response body if positive.
+	MaxBytes int64
+	// MaxRedirects is the number of redirects followed.
+	MaxRedirects int
+}
+
+// HTTP is an ImageBackend fetching images from an upstream web server.
+type HTTP struct {
+	base     *url.URL
+	hosts    map[string]bool
+	maxBytes int64
+	client   *http.Client
+}
+
+func NewHTTP(opt HTTPOptions) (*HTTP, error) {
+	h := &HTTP{
+		hosts:    make(map[string]bool),
+		maxBytes: opt.MaxBytes,
+	}
+
+	if opt.BaseURL != "" {
+		u, err := url.Parse(opt.BaseURL)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("http backend: invalid base url %q", opt.BaseURL)
+		}
+
+		h.base = u
+		h.hosts[u.Host] = true
+	} else if len(opt.AllowedHosts) == 0 {
+		return nil, errors.New("http backend: base url or allowed hosts required")
+	}
+
+	for _, host := range opt.AllowedHosts {
+		h.hosts[host] = true
+	}
+
+	h.client = &http.Client{
+		Timeout: opt.Timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > opt.MaxRedirects {
+				return fmt.Errorf("http backend: stopped after %d redirects", opt.MaxRedirects)
+			}
+
+			if !h.hosts[req.URL.Host] {
+				return fmt.Errorf("%w: redirect to host %s not allowed", ErrForbidden, req.URL.Host)
+			}
+
+			return nil
+		},
+	}
+
+	return h, nil
+}
+
+// url maps name onto the upstream URL. Without a base URL, name must be a
+// full URL of an allowed host. As file paths are cleaned, the double slash
+// after the scheme may be reduced to a single one.
+func (h *HTTP) url(name string) (*url.URL, error) {
+	if h.base != nil {
+		u := *h.base
+		u.Path = path.Join(h.base.Path, path.Clean("/"+name))
+		u.RawPath = ""
+		return &u, nil
+	}
+
+	name = strings.TrimLeft(name, "/")
+
+	for _, scheme := range []string{"http:", "https:"} {
+		if strings.HasPrefix(name, scheme) {
+			name = scheme + "//" + strings.TrimLeft(name[len(scheme):], "/")
+			break
+		}
+	}
+
+	u, err := url.Parse(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotFound, err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" || !h.hosts[u.Host] {
+		return nil, fmt.Errorf("%w: url %q not allowed", ErrForbidden, name)
+	}
+
+	return u, nil
+}
+
+// do sends a request and maps error responses to backend errors.
+func (h *HTTP) do(method, name string) (*http.Response, error) {
+	resp, err := h.send(method, name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return checkStatus(resp)
+}
+
+// send sends a request for the file name. Any response is returned.
+func (h *HTTP) send(method, name string) (*http.Response, error) {
+	u, err := h.url(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest(method, u.String(), nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := h.client.Do(req)
+
+	if err != nil {
+		if errors.Is(err, ErrForbidden) {
+			return nil, err
+		}
+
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	return resp, nil
+}
+
+// checkStatus returns resp if it is successful. Otherwise the body is
+// closed and the status is mapped to a backend error.
+func checkStatus(resp *http.Response) (*http.Response, error) {
+	var err error
+	u := resp.Request.URL
+
+	switch resp.StatusCode {
+	case 200:
+		return resp, nil
+	case 404, 410:
+		err = fmt.Errorf("%w: %s", ErrNotFound, u)
+	case 401, 403:
+		err = fmt.Errorf("%w: %s", ErrForbidden, u)
+	default:
+		err = fmt.Errorf("%w: %s: %s", ErrUnavailable, u, resp.Status)
+	}
+
+	resp.Body.Close()
+	return nil, err
+}
+
+func (h *HTTP) ReadFile(name string) ([]byte, error) {
+	rc, err := h.Open(name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// Stat sends a HEAD request. If the upstream rejects HEAD requests with 403
+// Forbidden, 405 Method Not Allowed or 501 Not Implemented, as GET-only
+// origins and presigned URLs do, an empty FileInfo is returned. The file is
+// then described by the GET request reading it.
+func (h *HTTP) Stat(name string) (*FileInfo, error) {
+	resp, err := h.send("HEAD", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusForbidden, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		resp.Body.Close()
+		return &FileInfo{}, nil
+	}
+
+	if resp, err = checkStatus(resp); err != nil {
+		return nil, err
+	}
+
+	resp.Body.Close()
+
+	fi := &FileInfo{
+		Size:        resp.ContentLength,
+		ETag:        strings.Trim(strings.TrimPrefix(resp.Header.Get("Etag"), "W/"), `"`),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+
+	// The size is unknown without Content-Length.
+	if fi.Size < 0 {
+		fi.Size = 0
+	}
+
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		fi.ModTime = t
+	}
+
+	return fi, nil
+}
+
+// Open sends a GET request and returns the response body. Reading more
+// than MaxBytes from the body returns ErrTooLarge.
+func (h *HTTP) Open(name string) (io.ReadCloser, error) {
+	resp, err := h.do("GET", name)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if h.maxBytes <= 0 {
+		return resp.Body, nil
+	}
+
+	if resp.ContentLength > h.maxBytes {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrTooLarge, resp.ContentLength, h.maxBytes)
+	}
+
+	return &maxBytesReader{rc: resp.Body, n: h.maxBytes}, nil
+}
+
+// maxBytesReader returns ErrTooLarge once more than n bytes are read.
+type maxBytesReader struct {
+	rc io.ReadCloser
+	n  int64
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.n+1 {
+		p = p[:r.n+1]
+	}
+
+	n, err := r.rc.Read(p)
+
+	if int64(n) > r.n {
+		return int(r.n), fmt.Errorf("%w: exceeds %d bytes", ErrTooLarge, r.n)
+	}
+
+	r.n -= int64(n)
+	return n, err
+}
+
+func (r *maxBytesReader) Close() error {
+	return r.rc.Close()
+}
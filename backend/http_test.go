@@ -0,0 +1,182 @@
+package backend
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newOrigin(t *testing.T) *httptest.Server {
+	data, err := ioutil.ReadFile("../image/fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	modTime := time.Date(2013, 1, 1, 0, 0, 0, 0, time.UTC)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/images/circle.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	})
+	mux.HandleFunc("/images/redirect.png", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/images/circle.png", http.StatusFound)
+	})
+	mux.HandleFunc("/images/loop.png", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/images/loop.png", http.StatusFound)
+	})
+	// GET-only origins reject HEAD requests in various ways.
+	for path, code := range map[string]int{
+		"/images/head-405.png": http.StatusMethodNotAllowed,
+		"/images/head-501.png": http.StatusNotImplemented,
+		"/images/head-403.png": http.StatusForbidden,
+	} {
+		code := code
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				w.WriteHeader(code)
+				return
+			}
+
+			w.Write(data)
+		})
+	}
+	mux.HandleFunc("/images/chunked.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.(http.Flusher).Flush()
+
+		if r.Method == "GET" {
+			w.Write(data)
+		}
+	})
+	mux.HandleFunc("/images/error.png", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusInternalServerError)
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestHTTP(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+
+	h, err := NewHTTP(HTTPOptions{BaseURL: origin.URL + "/images", MaxRedirects: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := h.ReadFile("circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := h.Stat("/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fi.Size != int64(len(data)) || fi.ModTime.IsZero() || fi.ETag != "abc" || fi.ContentType != "image/png" {
+		t.Fatalf("unexpected file info %+v", fi)
+	}
+
+	if _, err := h.ReadFile("redirect.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ReadFile("loop.png"); err == nil {
+		t.Fatal("expected redirect error")
+	}
+
+	if _, err := h.Stat("missing.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	// Paths must not escape the base URL.
+	if _, err := h.Stat("../images/circle.png"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := h.ReadFile("error.png"); !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+
+	h, err = NewHTTP(HTTPOptions{BaseURL: origin.URL + "/images", MaxBytes: 64})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ReadFile("circle.png"); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestHTTPAllowedHosts(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+
+	u, _ := url.Parse(origin.URL)
+	h, err := NewHTTP(HTTPOptions{AllowedHosts: []string{u.Host}})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// File paths are cleaned by the server, reducing // to /.
+	if _, err := h.ReadFile("http:/" + u.Host + "/images/circle.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ReadFile(origin.URL + "/images/circle.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ReadFile("http://example.com/images/circle.png"); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for host not allowed, got %v", err)
+	}
+
+	if _, err := h.ReadFile("images/circle.png"); err == nil {
+		t.Fatal("expected error for relative path")
+	}
+
+	if _, err := NewHTTP(HTTPOptions{}); err == nil {
+		t.Fatal("expected error without base url or hosts")
+	}
+}
+
+func TestHTTPStatWithoutHead(t *testing.T) {
+	origin := newOrigin(t)
+	defer origin.Close()
+
+	h, err := NewHTTP(HTTPOptions{BaseURL: origin.URL + "/images"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"head-405.png", "head-501.png", "head-403.png", "chunked.png"} {
+		fi, err := h.Stat(name)
+
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		if fi.Size != 0 || fi.ETag != "" || !fi.ModTime.IsZero() {
+			t.Fatalf("%s: expected empty file info got %+v", name, fi)
+		}
+
+		if _, err := h.ReadFile(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+}
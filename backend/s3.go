@@ -1,6 +1,13 @@
 package backend
 
 import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
 	"launchpad.net/goamz/aws"
 	"launchpad.net/goamz/s3"
 )
@@ -24,5 +31,70 @@ func NewS3(access, secret, region, bucket string) *S3 {
 }
 
 func (s *S3) ReadFile(filename string) ([]byte, error) {
-	return s.b.Get(filename)
+	data, err := s.b.Get(filename)
+
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return data, nil
+}
+
+// Stat issues a HEAD request for the object, which avoids downloading it and
+// needs only s3:GetObject. A missing or malformed Last-Modified header leaves
+// ModTime zero. The content type falls back to the file extension.
+func (s *S3) Stat(filename string) (*FileInfo, error) {
+	resp, err := s.b.Head(filename)
+
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	contentType := resp.Header.Get("Content-Type")
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(path.Ext(filename))
+	}
+
+	size := resp.ContentLength
+
+	if size < 0 {
+		size = 0
+	}
+
+	return &FileInfo{
+		Size:        size,
+		ModTime:     modTime,
+		ETag:        strings.Trim(resp.Header.Get("ETag"), `"`),
+		ContentType: contentType,
+	}, nil
+}
+
+func (s *S3) Open(filename string) (io.ReadCloser, error) {
+	rc, err := s.b.GetReader(filename)
+
+	if err != nil {
+		return nil, s3Error(err)
+	}
+
+	return rc, nil
+}
+
+// s3Error wraps S3 errors with the backend errors. Server errors and
+// failed requests are reported as ErrUnavailable.
+func s3Error(err error) error {
+	if e, ok := err.(*s3.Error); ok {
+		switch {
+		case e.StatusCode == 404:
+			return fmt.Errorf("%w: %v", ErrNotFound, err)
+		case e.StatusCode == 401 || e.StatusCode == 403:
+			return fmt.Errorf("%w: %v", ErrForbidden, err)
+		case e.StatusCode < 500:
+			return err
+		}
+	}
+
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
 }
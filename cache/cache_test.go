@@ -0,0 +1,75 @@
+package cache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func testCache(t *testing.T, c Cache) {
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("expected miss")
+	}
+
+	if err := c.Set("a", []byte("foo")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok := c.Get("a")
+
+	if !ok || !bytes.Equal(data, []byte("foo")) {
+		t.Fatalf("expected foo got %q", data)
+	}
+
+	if err := c.Set("a", []byte("bar")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, ok = c.Get("a")
+
+	if !ok || !bytes.Equal(data, []byte("bar")) {
+		t.Fatalf("expected bar got %q", data)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testCache(t, NewMemory(1024))
+}
+
+func TestMemoryEviction(t *testing.T) {
+	c := NewMemory(10)
+	c.Set("a", []byte("1234"))
+	c.Set("b", []byte("1234"))
+	c.Get("a")
+	c.Set("c", []byte("1234"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("expected b to be evicted")
+	}
+
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+
+	if c.Len() != 2 || c.Size() != 8 {
+		t.Fatalf("expected 2 entries of 8 bytes got %d entries of %d bytes", c.Len(), c.Size())
+	}
+
+	c.Set("d", []byte("12345678901"))
+
+	if _, ok := c.Get("d"); ok {
+		t.Fatal("expected oversized entry to be skipped")
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgfilter-cache")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+	testCache(t, Dir(dir))
+}
@@ -0,0 +1,64 @@
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Dir is an on-disk implementation of the Cache. Each entry is stored in a
+// file named by the SHA-1 hash of its key. Dir does not evict entries.
+type Dir string
+
+func (d Dir) path(key string) string {
+	sum := sha1.Sum([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(string(d), name[:2], name)
+}
+
+func (d Dir) Get(key string) ([]byte, bool) {
+	data, err := ioutil.ReadFile(d.path(key))
+
+	if err != nil {
+		return nil, false
+	}
+
+	return data, true
+}
+
+// Set writes data to a temporary file which is renamed into place, so
+// concurrent readers never see partial entries.
+func (d Dir) Set(key string, data []byte) error {
+	name := d.path(key)
+	dir := filepath.Dir(name)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, ".tmp-")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err = f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	if err = os.Rename(f.Name(), name); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+
+	return nil
+}
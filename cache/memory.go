@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+type entry struct {
+	key  string
+	data []byte
+}
+
+// Memory is an in-memory LRU implementation of the Cache. The least
+// recently used entries are evicted once the total size of the cached data
+// exceeds the byte budget.
+type Memory struct {
+	mu       sync.Mutex
+	maxBytes int64
+	nbytes   int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+// NewMemory returns a Memory cache holding at most maxBytes of data.
+func NewMemory(maxBytes int64) *Memory {
+	return &Memory{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (m *Memory) Get(key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.ll.MoveToFront(el)
+		return el.Value.(*entry).data, true
+	}
+
+	return nil, false
+}
+
+func (m *Memory) Set(key string, data []byte) error {
+	if int64(len(data)) > m.maxBytes {
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if el, ok := m.items[key]; ok {
+		m.ll.MoveToFront(el)
+		e := el.Value.(*entry)
+		m.nbytes += int64(len(data) - len(e.data))
+		e.data = data
+	} else {
+		m.items[key] = m.ll.PushFront(&entry{key: key, data: data})
+		m.nbytes += int64(len(data))
+	}
+
+	for m.nbytes > m.maxBytes {
+		m.removeOldest()
+	}
+
+	return nil
+}
+
+// Len returns the number of cached entries.
+func (m *Memory) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.ll.Len()
+}
+
+// Size returns the total size of the cached data in bytes.
+func (m *Memory) Size() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.nbytes
+}
+
+func (m *Memory) removeOldest() {
+	el := m.ll.Back()
+
+	if el == nil {
+		return
+	}
+
+	m.ll.Remove(el)
+	e := el.Value.(*entry)
+	delete(m.items, e.key)
+	m.nbytes -= int64(len(e.data))
+}
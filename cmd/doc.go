@@ -31,6 +31,63 @@
 //             help text
 //     -http=":8080"
 //             set bind address for the HTTP server
+//     -http-max-age=0s
+//             Cache-Control max-age of image responses, e.g. 24h.
+//             No Cache-Control header is sent if zero
+//     -http-immutable=false
+//             add immutable to the Cache-Control header
+//     -shutdown-timeout=30s
+//             time to wait for in-flight requests on shutdown
+//     -json-errors=false
+//             write error responses as JSON objects with a stable error
+//             code, see Errors
+//     -metrics-path="/metrics"
+//             path of the Prometheus metrics endpoint, see Metrics.
+//             Disabled if empty
+//     -probe-file=""
+//             backend file looked up by /readyz, see Health Checks.
+//             The backend is not checked if empty
+//     -max-concurrency=0
+//             maximum concurrent image operations, 0 uses the number of CPUs
+//     -max-queue=100
+//             maximum requests waiting for an image operation. Further
+//             requests are rejected with 503 Service Unavailable
+//     -max-source-size=50
+//             maximum source image size in MB. Larger images are rejected
+//             with 413 Request Entity Too Large
+//     -max-source-pixels=100000000
+//             maximum source image pixels, counting all frames. Checked
+//             before the image is decoded
+//     -max-width=8192
+//             maximum output image width. Larger outputs are rejected with
+//             422 Unprocessable Entity
+//     -max-height=8192
+//             maximum output image height
+//     -auto-orient=true
+//             rotate and flip images as described by their EXIF
+//             orientation before any operation. The orientation is reset
+//             to top-left in the output
+//     -background="white"
+//             color transparent images are flattened against when the
+//             output format lacks transparency, e.g. JPEG, see bg in output
+//             format
+//     -im-memory=0
+//             ImageMagick memory limit in MB
+//     -im-map=0
+//             ImageMagick memory map limit in MB
+//     -im-disk=0
+//             ImageMagick disk limit in MB
+//     -im-threads=0
+//             ImageMagick thread limit
+//     -sign-secret=""
+//             If non-empty, only serve URLs signed with this secret
+//     -formats="avif,webp"
+//             output formats negotiated from the Accept header, in order
+//             of preference. Negotiation is disabled if empty
+//     -quality=85
+//             default quality of lossy output formats
+//     -max-quality=95
+//             maximum quality of lossy output formats
 //     -fs-base-dir=""
 //             file system base dir
 //     -aws-access-key-id=""
@@ -41,12 +98,31 @@
 //             AWS region
 //     -aws-bucket=""
 //             AWS bucket
-//     -log=0
-//             log level
+//     -origin-base-url=""
+//             upstream base URL source image paths are resolved against
+//     -origin-hosts=""
+//             comma separated hosts which may be fetched when the source
+//             path is a full URL, e.g. /resize/200x/https://example.com/a.png
+//     -origin-timeout=10s
+//             timeout of upstream requests
+//     -origin-max-redirects=3
+//             maximum redirects followed by upstream requests
+//     -cache=""
+//             cache type, either memory or dir. Disabled if empty
+//     -cache-size=64
+//             memory cache size in MB
+//     -cache-dir=""
+//             cache dir used by the dir cache
+//     -log="info"
+//             log level, one of debug, info or error
+//     -log-format="text"
+//             log format, text or json. Each request is logged as an
+//             access log line, see Access Log
 //     -log-file=""
-//             If non-empty, write log to this file
+//             If non-empty, write log to this file. The file is reopened
+//             on SIGHUP
 //     -log-raven-dsn=""
-//             If non-empty, write to raven dsn
+//             If non-empty, send errors to this Sentry compatible dsn
 //     -version=false
 //             display version number and exit
 //     -debug.cpuprofile=""
@@ -66,7 +142,9 @@
 //
 // CROP IMAGE
 //
-// Region can be specified as widthxheight{+-}x{+-}y{%}
+// Region can be specified as widthxheight{+-}x{+-}y{%}. As in ImageMagick,
+// flags may follow the size or the offsets, e.g. 100x100%+10+10 or
+// 100x100+10+10%, but not appear inside a number.
 //
 //
 // Example
@@ -111,4 +189,237 @@
 //
 //		GET /thumbnail/78x110/filename.png
 //
+// PAD IMAGE
+//
+// Pad size can be specified as widthxheight{%} {@} {<} {>}.
+//
+// The image is scaled to fit the width and height while maintaining the aspect
+// ratio and the canvas is then extended to exactly width×height. An optional
+// gravity direction segment places the image on the canvas, centered by default.
+// The added area is filled with the color given in the bg query parameter, see
+// output format. It is transparent by default, or the -background color if the
+// output format is JPEG.
+//
+// Example
+//
+// Fit an image in a 300×250 white canvas, aligned to the left.
+//
+//		GET /pad/300x250/west/filename.jpg?bg=ffffff
+//
+// ROTATE AND FLIP IMAGE
+//
+// /rotate/{degrees}/{path} rotates an image clockwise by -360 to 360 degrees.
+// Multiples of 90 are lossless. Other angles enlarge the image to hold the
+// rotated image and the corners are filled with the bg color, transparent by
+// default.
+//
+// /flip/{direction}/{path} mirrors an image. The direction is v to flip it top
+// to bottom, h to flip it left to right or hv for both.
+//
+// Example
+//
+// Rotate an image a quarter turn counterclockwise and mirror it left to right.
+//
+//		GET /rotate/270/filename.jpg
+//		GET /flip/h/filename.jpg
+//
+// GRAVITY
+//
+// Crop and thumbnail take an optional gravity direction segment before the file
+// name which positions the crop region: north, northeast, east, southeast, south,
+// southwest, west, northwest or center. Pad only uses the compass directions.
+//
+// The smart, entropy and attention directions choose the region by analysing a
+// downscaled copy of the image. Entropy picks the region with the most detail.
+// Attention favours edges, saturated colours and skin tones. Smart is the same as
+// attention.
+//
+// A focal point given as fractions of the image width and height, fp:x,y,
+// centers the region on the point as far as the image bounds allow. fp:0,0 is the
+// top left corner and fp:1,1 the bottom right corner.
+//
+// Example
+//
+// Generate a 200×200 thumbnail keeping the most interesting part of the image.
+//
+//		GET /thumbnail/200x200^/smart/filename.jpg
+//
+// Crop a 300×200 region around a point 30% from the left and 70% from the top.
+//
+//		GET /crop/300x200/fp:0.3,0.7/filename.jpg
+//
+// PIPELINE
+//
+// Several operations can be applied to an image in a single request. The
+// operations are given as name:argument path segments before the file name and
+// are applied in order.
+//
+//     crop:geometry
+//             crop a region, see crop image
+//     resize:geometry
+//             resize, see resize image
+//     thumbnail:geometry
+//             thumbnail, see thumbnail image
+//     pad:geometry
+//             fit and pad, see pad image
+//     rotate:degrees
+//             rotate clockwise, see rotate and flip image
+//     flip:direction
+//             mirror, one of v, h or hv, see rotate and flip image
+//     gravity:direction
+//             set the gravity direction of following crop, thumbnail and pad
+//             operations, see gravity
+//     fp:x,y
+//             set a focal point as gravity, the same as gravity:fp:x,y
+//     sharpen:{radiusx}sigma
+//             sharpen the image
+//
+// The encoder parameters described in output format can be given as segments as
+// well, e.g. q:80. At most 16 segments are allowed.
+//
+// Example
+//
+// Crop a 100×100 region offset 10 pixels from the top left corner, resize it
+// to 50×50, sharpen it and encode it with quality 80.
+//
+//		GET /p/crop:100x100+10+10/resize:50x50/sharpen:1/q:80/filename.png
+//
+// OUTPUT FORMAT
+//
+// By default images are returned in the format of the source image. The output
+// format can be selected with the fm query parameter, one of jpeg, png, gif,
+// webp and avif.
+//
+// If no format is selected, imgfilter picks the first format in -formats
+// listed in the request's Accept header and sets Vary: Accept on the response.
+//
+// Example
+//
+// Generate a 78×110 WebP thumbnail of an image.
+//
+//		GET /thumbnail/78x110/filename.png?fm=webp
+//
+// The encoder is controlled with the following query parameters.
+//
+//     q=1-100
+//             quality of JPEG, WebP and AVIF output. Defaults to -quality and
+//             is capped at -max-quality
+//     progressive=true|false
+//             progressive JPEG or interlaced PNG output
+//     chroma=420|422|444
+//             JPEG chroma subsampling
+//     compression=1-9
+//             PNG compression level
+//     strip=true|false
+//             remove profiles and comments
+//     bg=color
+//             background color of padded and rotated areas, hex RGB or
+//             RGBA such as ff0000 or ff000080, or a color name such as
+//             white or none. Transparent images are flattened against an
+//             opaque color
+//
+// Example
+//
+// Generate a 78×110 progressive JPEG thumbnail of quality 70 with metadata
+// stripped.
+//
+//		GET /thumbnail/78x110/filename.png?fm=jpeg&q=70&progressive=1&strip=1
+//
+// IMAGE INFO
+//
+// /info/{path} describes a source image as JSON: width, height, format, alpha
+// presence, EXIF orientation (1-8, 0 if undefined), color space, ICC profile
+// description, frame count and size in bytes. The output size of a crop, pad,
+// resize or thumbnail is included if its geometry is given in the query
+// parameter of that name. Only the image header is read. Responses carry an
+// ETag and are cached like images.
+//
+// Example
+//
+//		GET /info/filename.jpg?thumbnail=78x110
+//
+//		{"width":1024,"height":768,"format":"jpeg","alpha":false,"orientation":1,
+//		 "color_space":"srgb","frames":1,"size":183412,
+//		 "output":{"thumbnail":{"width":78,"height":110}}}
+//
+// SIGNED URLS
+//
+// If imgfilter is started with -sign-secret, only URLs carrying a valid
+// HMAC-SHA256 signature are served. The signature is passed in the s query
+// parameter and covers the URL path and all other query parameters. An optional
+// expiry unix timestamp is passed in the e query parameter. Requests with a
+// missing, invalid or expired signature are rejected with 403 Forbidden.
+//
+// Signed URLs are generated with the sign package.
+//
+//		s := sign.New("secret")
+//		u := s.Sign("/thumbnail/78x110/filename.png", nil, time.Now().Add(time.Hour))
+//
+// ERRORS
+//
+// Errors are reported with the following status codes. With -json-errors the
+// response body is a JSON object holding a stable error code and a message.
+// The messages of 5xx errors are only logged.
+//
+//		{"code":"not_found","message":"backend: file not found: ..."}
+//
+//		400  bad_request
+//		     invalid geometry, parameter or format
+//		403  missing_signature, invalid_signature, expired_signature, forbidden
+//		     signature rejected or source access denied
+//		404  not_found
+//		     source image not found
+//		413  source_too_large
+//		     source image exceeds the size limits
+//		415  unsupported_format
+//		     source image cannot be decoded or output format cannot be encoded
+//		422  output_too_large
+//		     output image exceeds the size limits
+//		500  internal
+//		     image operation failed
+//		502  backend_unavailable
+//		     backend failed or cannot be reached
+//		503  busy
+//		     too many requests waiting
+//
+// ACCESS LOG
+//
+// Each image request is logged with the fields method, path, status, bytes,
+// source_size, output_size, cache_hit, shared and, for failed requests, error.
+// The time spent is given in milliseconds by fetch_ms (backend), decode_ms,
+// transform_ms, encode_ms and duration_ms (total). The signature of signed URLs
+// is removed from the logged path.
+//
+//		2013-01-01T00:00:00Z info access bytes=1043 cache_hit=false decode_ms=2.1 ...
+//
+// With -log-format=json each line is a JSON object.
+//
+// METRICS
+//
+// Metrics are served in the Prometheus text format at -metrics-path.
+//
+//		imgfilter_requests_total{route,status}
+//		imgfilter_request_duration_seconds{route}
+//		imgfilter_phase_duration_seconds{phase}     fetch, decode, transform, encode
+//		imgfilter_response_bytes_total{route}
+//		imgfilter_source_bytes_total
+//		imgfilter_image_operations_in_flight
+//		imgfilter_cache_requests_total{result}      hit, miss
+//		imgfilter_backend_requests_total{op}        stat, open, read
+//		imgfilter_backend_errors_total{op,code}
+//		imgfilter_backend_duration_seconds{op}
+//
+// HEALTH CHECKS
+//
+// /healthz reports that the process is alive. /readyz checks that
+// ImageMagick decodes and encodes a tiny built-in image and, if -probe-file is
+// set, that the file is found in the backend. The image check does not wait for
+// an image operation slot, so a busy server stays ready. Each check fails after
+// 2 seconds.
+// It responds with 503 Service Unavailable if a check fails.
+//
+//		GET /readyz
+//
+//		{"status":"ok","checks":{"backend":{"status":"ok","latency_ms":0.8},"imagemagick":{"status":"ok","latency_ms":0.3}}}
+//
 package main
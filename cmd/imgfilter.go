@@ -6,9 +6,14 @@ import (
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"strings"
+	"time"
 
 	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/cache"
+	"github.com/simonz05/imgfilter/image"
 	"github.com/simonz05/imgfilter/server"
+	"github.com/simonz05/imgfilter/sign"
 	"github.com/simonz05/util/log"
 )
 
@@ -21,6 +26,39 @@ var (
 	awsSecretAccessKey = flag.String("aws-secret-access-key", "", "AWS secret access key")
 	awsRegion          = flag.String("aws-region", "", "AWS region")
 	awsBucket          = flag.String("aws-bucket", "", "AWS bucket")
+	originBaseURL      = flag.String("origin-base-url", "", "upstream base URL source image paths are resolved against")
+	originHosts        = flag.String("origin-hosts", "", "comma separated hosts which may be fetched given full source URLs")
+	originTimeout      = flag.Duration("origin-timeout", 10*time.Second, "timeout of upstream requests")
+	originRedirects    = flag.Int("origin-max-redirects", 3, "maximum redirects followed by upstream requests")
+	cacheType          = flag.String("cache", "", "cache type, memory or dir")
+	cacheSize          = flag.Int64("cache-size", 64, "memory cache size in MB")
+	cacheDir           = flag.String("cache-dir", "", "cache dir")
+	httpMaxAge         = flag.Duration("http-max-age", 0, "Cache-Control max-age of image responses")
+	httpImmutable      = flag.Bool("http-immutable", false, "mark image responses as immutable")
+	signSecret         = flag.String("sign-secret", "", "if non-empty, require URLs signed with this secret")
+	quality            = flag.Uint("quality", 85, "default quality of lossy output formats")
+	maxQuality         = flag.Uint("max-quality", 95, "maximum quality of lossy output formats")
+	maxConcurrency     = flag.Int("max-concurrency", 0, "maximum concurrent image operations, 0 uses the number of CPUs")
+	maxQueue           = flag.Int("max-queue", 100, "maximum requests waiting for an image operation")
+	maxSourceSize      = flag.Int64("max-source-size", 50, "maximum source image size in MB")
+	maxSourcePixels    = flag.Uint64("max-source-pixels", 100000000, "maximum source image pixels, counting all frames")
+	maxWidth           = flag.Uint("max-width", 8192, "maximum output image width")
+	maxHeight          = flag.Uint("max-height", 8192, "maximum output image height")
+	background         = flag.String("background", "white", "color transparent images are flattened against when the output format lacks transparency")
+	autoOrient         = flag.Bool("auto-orient", true, "rotate and flip images as described by their EXIF orientation")
+	imMemory           = flag.Int64("im-memory", 0, "ImageMagick memory limit in MB")
+	imMap              = flag.Int64("im-map", 0, "ImageMagick memory map limit in MB")
+	imDisk             = flag.Int64("im-disk", 0, "ImageMagick disk limit in MB")
+	imThreads          = flag.Int64("im-threads", 0, "ImageMagick thread limit")
+	probeFile          = flag.String("probe-file", "", "backend file looked up by the readiness check, the backend is not checked if empty")
+	metricsPath        = flag.String("metrics-path", "/metrics", "path of the Prometheus metrics endpoint, disabled if empty")
+	jsonErrors         = flag.Bool("json-errors", false, "write error responses as JSON")
+	shutdownTimeout    = flag.Duration("shutdown-timeout", 30*time.Second, "time to wait for in-flight requests on shutdown")
+	formats            = flag.String("formats", "avif,webp", "output formats negotiated from the Accept header, in order of preference")
+	logLevel           = flag.String("log", "info", "log level, one of debug, info or error")
+	logFormat          = flag.String("log-format", "text", "log format, text or json")
+	logFile            = flag.String("log-file", "", "if non-empty, write log to this file")
+	logRavenDSN        = flag.String("log-raven-dsn", "", "if non-empty, send errors to this Sentry compatible DSN")
 	cpuprofile         = flag.String("debug.cpuprofile", "", "write cpu profile to file")
 )
 
@@ -62,20 +100,140 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	var imgBackend backend.ImageBackend
+	var (
+		imgBackend backend.ImageBackend
+		err        error
+	)
 
 	if *fsBaseDir != "" {
 		imgBackend = backend.Dir(*fsBaseDir)
 	} else if *awsAccessKeyId != "" && *awsSecretAccessKey != "" && *awsRegion != "" && *awsBucket != "" {
 		imgBackend = backend.NewS3(*awsAccessKeyId, *awsSecretAccessKey, *awsRegion, *awsBucket)
+	} else if *originBaseURL != "" || *originHosts != "" {
+		var hosts []string
+
+		if *originHosts != "" {
+			hosts = strings.Split(*originHosts, ",")
+		}
+
+		imgBackend, err = backend.NewHTTP(backend.HTTPOptions{
+			BaseURL:      *originBaseURL,
+			AllowedHosts: hosts,
+			Timeout:      *originTimeout,
+			MaxBytes:     *maxSourceSize << 20,
+			MaxRedirects: *originRedirects,
+		})
+
+		if err != nil {
+			log.Fatal(err)
+		}
 	} else {
-		log.Errorln("Expected either aws-* or fs-* arguments")
+		log.Errorln("Expected either aws-*, fs-* or origin-* arguments")
+		os.Exit(1)
+	}
+
+	var imgCache cache.Cache
+
+	switch *cacheType {
+	case "":
+	case "memory":
+		imgCache = cache.NewMemory(*cacheSize << 20)
+	case "dir":
+		if *cacheDir == "" {
+			log.Errorln("Expected cache-dir argument")
+			os.Exit(1)
+		}
+		imgCache = cache.Dir(*cacheDir)
+	default:
+		log.Errorf("Unknown cache type %q", *cacheType)
+		os.Exit(1)
+	}
+
+	logger, closeLog, err := newLogger()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defer closeLog()
+
+	// fatal logs err and exits. Deferred calls don't run on exit, so the
+	// log is closed first to flush the error sink.
+	fatal := func(err error) {
+		logger.Errorf("%v", err)
+		closeLog()
 		os.Exit(1)
 	}
 
-	err := server.ListenAndServe(*laddr, imgBackend)
+	opt := server.Options{
+		Backend: imgBackend,
+		Cache:   imgCache,
+		CacheControl: server.CacheControl{
+			MaxAge:    *httpMaxAge,
+			Immutable: *httpImmutable,
+		},
+		Quality:         *quality,
+		MaxQuality:      *maxQuality,
+		Background:      *background,
+		KeepOrientation: !*autoOrient,
+		Limits: image.Limits{
+			MaxBytes:  *maxSourceSize << 20,
+			MaxPixels: *maxSourcePixels,
+			MaxWidth:  *maxWidth,
+			MaxHeight: *maxHeight,
+		},
+		MaxConcurrency:  *maxConcurrency,
+		MaxQueue:        *maxQueue,
+		JSONErrors:      *jsonErrors,
+		MetricsPath:     *metricsPath,
+		ProbeFile:       *probeFile,
+		ShutdownTimeout: *shutdownTimeout,
+		Logger:          logger,
+	}
+
+	if opt.MaxConcurrency == 0 {
+		opt.MaxConcurrency = runtime.NumCPU()
+	}
+
+	err = image.SetResourceLimits(image.ResourceLimits{
+		Memory:  *imMemory << 20,
+		Map:     *imMap << 20,
+		Disk:    *imDisk << 20,
+		Threads: *imThreads,
+	})
 
 	if err != nil {
-		log.Println(err)
+		fatal(err)
 	}
+
+	if *signSecret != "" {
+		opt.Signer = sign.New(*signSecret)
+	}
+
+	if *formats != "" {
+		for _, v := range strings.Split(*formats, ",") {
+			f, err := image.ParseFormat(strings.TrimSpace(v))
+
+			if err != nil {
+				fatal(err)
+			}
+
+			if !image.Supported(f) {
+				logger.Printf("Format %s not supported by ImageMagick", f)
+				continue
+			}
+
+			opt.Formats = append(opt.Formats, f)
+		}
+	}
+
+	err = server.ListenAndServe(*laddr, opt)
+
+	if err != nil {
+		logger.Errorf("%v", err)
+		return
+	}
+
+	// All requests are done, so no image is in use.
+	image.Terminate()
 }
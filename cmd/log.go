@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/simonz05/imgfilter/logging"
+	"github.com/simonz05/imgfilter/server"
+)
+
+// accessLogger writes server access log entries as structured messages.
+type accessLogger struct {
+	*logging.Logger
+}
+
+func (l accessLogger) Access(e *server.AccessEntry) {
+	l.Log(logging.LevelInfo, "access", logging.Fields(e.Fields()))
+}
+
+// newLogger returns the logger configured by the log flags and a function
+// releasing its file and error sink. The log file is reopened on SIGHUP,
+// which is ignored otherwise.
+func newLogger() (*accessLogger, func(), error) {
+	level, err := logging.ParseLevel(*logLevel)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var json bool
+
+	switch *logFormat {
+	case "text":
+	case "json":
+		json = true
+	default:
+		return nil, nil, fmt.Errorf("unknown log format %q", *logFormat)
+	}
+
+	var (
+		w       io.Writer = os.Stderr
+		closers []io.Closer
+	)
+
+	if *logFile != "" {
+		f, err := logging.OpenFile(*logFile)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		w = f
+		closers = append(closers, f)
+	}
+
+	l := &accessLogger{logging.New(w, level, json)}
+
+	// SIGHUP is always trapped so that it doesn't terminate the process
+	// when no log file is configured.
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+
+	go func() {
+		for range c {
+			if f, ok := w.(*logging.File); ok {
+				if err := f.Reopen(); err != nil {
+					l.Errorf("reopen log file: %v", err)
+				}
+			}
+		}
+	}()
+
+	if *logRavenDSN != "" {
+		r, err := logging.NewRaven(*logRavenDSN)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		l.SetSink(r)
+		closers = append([]io.Closer{r}, closers...)
+	}
+
+	closeFn := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+	}
+
+	return l, closeFn, nil
+}
@@ -0,0 +1,113 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+var colorRe = regexp.MustCompile("^(#?([0-9a-f]{3,4}|[0-9a-f]{6}|[0-9a-f]{8})|[a-z]+)$")
+
+// ParseColor parses a color given as hex RGB or RGBA, e.g. ff0000 or
+// #ff000080, or as an ImageMagick color name such as white or none. Hex
+// colors are returned prefixed by #. Names unknown to ImageMagick are
+// rejected.
+func ParseColor(s string) (string, error) {
+	c := strings.ToLower(s)
+
+	if !colorRe.MatchString(c) {
+		return "", fmt.Errorf("invalid color %q", s)
+	}
+
+	if c[0] == '#' || strings.Trim(c, "0123456789abcdef") == "" {
+		return "#" + strings.TrimPrefix(c, "#"), nil
+	}
+
+	pw, err := colorWand(c)
+
+	if err != nil {
+		return "", fmt.Errorf("invalid color %q", s)
+	}
+
+	pw.Destroy()
+	return c, nil
+}
+
+// SetBackground sets the color of areas added by Pad and Rotate.
+// Transparent if empty.
+func (im *Image) SetBackground(color string) {
+	im.background = color
+}
+
+// colorWand returns a pixel wand of color.
+func colorWand(color string) (*imagick.PixelWand, error) {
+	pw := imagick.NewPixelWand()
+
+	if !pw.SetColor(color) {
+		pw.Destroy()
+		return nil, fmt.Errorf("invalid color %q", color)
+	}
+
+	return pw, nil
+}
+
+// fillWand returns a pixel wand of the background color of areas added to
+// the image. The alpha channel of the image is enabled if the color is
+// transparent.
+func (im *Image) fillWand() (*imagick.PixelWand, error) {
+	color := im.background
+
+	if color == "" {
+		color = "none"
+	}
+
+	bg, err := colorWand(color)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if bg.GetAlpha() < 1 {
+		if err = im.mw.SetImageAlphaChannel(imagick.ALPHA_CHANNEL_SET); err != nil {
+			bg.Destroy()
+			return nil, err
+		}
+	}
+
+	return bg, nil
+}
+
+// flatten blends the transparent areas of the image with color and removes
+// the alpha channel. Nothing is done for a transparent color unless format
+// lacks transparency, in which case fallback is used.
+func (im *Image) flatten(color, fallback string, format Format) error {
+	if !im.mw.GetImageAlphaChannel() {
+		return nil
+	}
+
+	bg, err := colorWand(color)
+
+	if err != nil {
+		return err
+	}
+
+	defer bg.Destroy()
+
+	if bg.GetAlpha() < 1 {
+		if format.Alpha() {
+			return nil
+		}
+
+		if !bg.SetColor(fallback) {
+			return fmt.Errorf("invalid color %q", fallback)
+		}
+	}
+
+	if err = im.mw.SetImageBackgroundColor(bg); err != nil {
+		return err
+	}
+
+	return im.mw.SetImageAlphaChannel(imagick.ALPHA_CHANNEL_REMOVE)
+}
@@ -0,0 +1,56 @@
+package image
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// focalPrefix starts a direction giving a focal point, e.g. fp:0.3,0.7.
+const focalPrefix = "fp:"
+
+// FocalPoint is a point of interest in an image given as fractions of the
+// image width and height. 0,0 is the top left corner and 1,1 the bottom
+// right corner.
+type FocalPoint struct {
+	X, Y float64
+}
+
+// ParseFocalPoint parses a focal point of the form x,y optionally prefixed
+// by fp:.
+func ParseFocalPoint(s string) (*FocalPoint, error) {
+	v := strings.TrimPrefix(s, focalPrefix)
+	i := strings.IndexByte(v, ',')
+
+	if i < 0 {
+		return nil, fmt.Errorf("invalid focal point %q", s)
+	}
+
+	x, err := strconv.ParseFloat(v[:i], 64)
+
+	if err != nil || math.IsNaN(x) || x < 0 || x > 1 {
+		return nil, fmt.Errorf("invalid focal point %q", s)
+	}
+
+	y, err := strconv.ParseFloat(v[i+1:], 64)
+
+	if err != nil || math.IsNaN(y) || y < 0 || y > 1 {
+		return nil, fmt.Errorf("invalid focal point %q", s)
+	}
+
+	return &FocalPoint{x, y}, nil
+}
+
+// String returns the focal point as a direction, e.g. fp:0.3,0.7.
+func (fp *FocalPoint) String() string {
+	return focalPrefix + strconv.FormatFloat(fp.X, 'g', -1, 64) + "," + strconv.FormatFloat(fp.Y, 'g', -1, 64)
+}
+
+// offset returns the offset of a w x h window centered on the focal point
+// of an image of size imW x imH. The window may exceed the image bounds.
+func (fp *FocalPoint) offset(imW, imH, w, h uint) (x, y int) {
+	x = int(math.Floor(fp.X*float64(imW)+0.5)) - int(w/2)
+	y = int(math.Floor(fp.Y*float64(imH)+0.5)) - int(h/2)
+	return
+}
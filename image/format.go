@@ -0,0 +1,181 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// ErrUnsupportedFormat is returned for images in a format ImageMagick has
+// no decoder for and output formats which cannot be encoded.
+var ErrUnsupportedFormat = errors.New("image: unsupported format")
+
+// readError classifies an error reading an image. Only a missing decoder
+// makes the format unsupported. Other failures, such as corrupt data or
+// exceeded resource limits, are returned as is.
+func readError(err error) error {
+	if e, ok := err.(*imagick.MagickWandException); ok && e.Kind() == imagick.EXCEPTION_MISSING_DELEGATE_ERROR {
+		return fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	return err
+}
+
+// Format is an image encoding format.
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+	FormatGIF  Format = "gif"
+	FormatWebP Format = "webp"
+	FormatAVIF Format = "avif"
+)
+
+var mimeTypes = map[Format]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatGIF:  "image/gif",
+	FormatWebP: "image/webp",
+	FormatAVIF: "image/avif",
+}
+
+// ParseFormat parses a format name such as jpeg, jpg, png, gif, webp or
+// avif.
+func ParseFormat(s string) (Format, error) {
+	f := Format(strings.ToLower(s))
+
+	if f == "jpg" {
+		f = FormatJPEG
+	}
+
+	if _, ok := mimeTypes[f]; !ok {
+		return "", fmt.Errorf("unknown format %q", s)
+	}
+
+	return f, nil
+}
+
+// MimeType returns the MIME type of the format.
+func (f Format) MimeType() string {
+	return mimeTypes[f]
+}
+
+var (
+	supportedMu sync.Mutex
+	supported   = make(map[Format]bool)
+)
+
+// Supported reports whether ImageMagick is able to encode images in
+// format f.
+func Supported(f Format) bool {
+	supportedMu.Lock()
+	defer supportedMu.Unlock()
+
+	if ok, found := supported[f]; found {
+		return ok
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	ok := len(mw.QueryFormats(strings.ToUpper(string(f)))) > 0
+	supported[f] = ok
+	return ok
+}
+
+// Alpha reports whether the format supports transparency.
+func (f Format) Alpha() bool {
+	return f != FormatJPEG
+}
+
+// Lossy reports whether the format uses lossy compression controlled by a
+// quality setting.
+func (f Format) Lossy() bool {
+	return f == FormatJPEG || f == FormatWebP || f == FormatAVIF
+}
+
+var subsamplings = map[string]string{
+	"420": "4:2:0",
+	"422": "4:2:2",
+	"444": "4:4:4",
+}
+
+// ParseSubsampling parses a chroma subsampling of the form 420, 422 or 444.
+func ParseSubsampling(s string) (string, error) {
+	v, ok := subsamplings[strings.Replace(s, ":", "", -1)]
+
+	if !ok {
+		return "", fmt.Errorf("unknown chroma subsampling %q", s)
+	}
+
+	return v, nil
+}
+
+// EncodeOptions controls how Encode writes an image. The zero value keeps
+// the format and the ImageMagick defaults.
+type EncodeOptions struct {
+	// Format is the output format. The source format is kept if empty.
+	Format Format
+	// Quality is the JPEG, WebP or AVIF quality, 1-100.
+	Quality uint
+	// Progressive enables progressive JPEG or interlaced PNG output.
+	Progressive bool
+	// Subsampling is the JPEG chroma subsampling, e.g. 4:2:0.
+	Subsampling string
+	// Compression is the PNG zlib compression level, 1-9.
+	Compression uint
+	// Strip removes all profiles and comments.
+	Strip bool
+	// Background is the color of areas added by padding and rotation.
+	// Transparent images are flattened against it. Added areas are
+	// transparent if empty and the output format supports transparency.
+	// Images encoded in formats without transparency use
+	// DefaultBackground otherwise.
+	Background string
+	// DefaultBackground is the color transparent images are flattened
+	// against when encoded in a format without transparency and
+	// Background is empty or transparent. White if empty.
+	DefaultBackground string
+	// KeepOrientation keeps the EXIF orientation of the source image
+	// instead of rotating and flipping the image accordingly.
+	KeepOrientation bool
+	// Limits restricts the source image and the output of the operations
+	// of a pipeline. It does not change the output and is not part of
+	// String.
+	Limits Limits
+}
+
+// background returns the background color of an image of format source.
+func (o *EncodeOptions) background(source Format) string {
+	format := o.Format
+
+	if format == "" {
+		format = source
+	}
+
+	if o.Background == "" && !format.Alpha() {
+		return o.defaultBackground()
+	}
+
+	return o.Background
+}
+
+// defaultBackground returns the color transparent images are flattened
+// against in formats without transparency.
+func (o *EncodeOptions) defaultBackground() string {
+	if o.DefaultBackground == "" {
+		return "white"
+	}
+
+	return o.DefaultBackground
+}
+
+// String returns a canonical representation of the options.
+func (o *EncodeOptions) String() string {
+	return fmt.Sprintf("fm=%s,q=%d,progressive=%t,chroma=%s,compression=%d,strip=%t,bg=%s,defbg=%s,keeporient=%t",
+		o.Format, o.Quality, o.Progressive, o.Subsampling, o.Compression, o.Strip, o.Background, o.defaultBackground(), o.KeepOrientation)
+}
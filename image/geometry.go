@@ -0,0 +1,268 @@
+package image
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// GeometryFlag modifies how a geometry is applied to an image.
+type GeometryFlag uint
+
+const (
+	// GeometryPercent (%) interprets width and height as percentages of the
+	// image size.
+	GeometryPercent GeometryFlag = 1 << iota
+	// GeometryArea (@) interprets width (or width*height) as the maximum
+	// area in pixels.
+	GeometryArea
+	// GeometryExact (!) ignores the aspect ratio of the image.
+	GeometryExact
+	// GeometryEnlarge (<) only enlarges images smaller than the geometry.
+	GeometryEnlarge
+	// GeometryShrink (>) only shrinks images larger than the geometry.
+	GeometryShrink
+	// GeometryFill (^) treats width and height as minimum values.
+	GeometryFill
+)
+
+var geometryFlags = []struct {
+	c    byte
+	flag GeometryFlag
+}{
+	{'%', GeometryPercent},
+	{'@', GeometryArea},
+	{'!', GeometryExact},
+	{'<', GeometryEnlarge},
+	{'>', GeometryShrink},
+	{'^', GeometryFill},
+}
+
+var geometryRe = regexp.MustCompile(`^([0-9]*)(x([0-9]*))?([%@!<>^]*)([-+][0-9]+)?([-+][0-9]+)?$`)
+
+// Geometry is an ImageMagick style geometry specification of the form
+// widthxheight{+-}x{+-}y{%}{@}{!}{<}{>}{^}. A zero width or height means the
+// value was omitted.
+type Geometry struct {
+	Width, Height uint
+	X, Y          int
+	Flags         GeometryFlag
+}
+
+// ParseGeometry parses a geometry string such as 640x480!, 200x, x256,
+// 50% or 100x100+10-10. Flags follow the size or the offsets, e.g.
+// 100x100^+10+10 or 100x100+10+10^.
+func ParseGeometry(s string) (*Geometry, error) {
+	g := new(Geometry)
+	rest := s
+
+	for len(rest) > 0 {
+		flag := geometryFlag(rest[len(rest)-1])
+
+		if flag == 0 {
+			break
+		}
+
+		g.Flags |= flag
+		rest = rest[:len(rest)-1]
+	}
+
+	result := geometryRe.FindStringSubmatch(rest)
+
+	if result == nil || (result[1] == "" && result[3] == "") {
+		return nil, fmt.Errorf("invalid geometry %q", s)
+	}
+
+	for i := 0; i < len(result[4]); i++ {
+		g.Flags |= geometryFlag(result[4][i])
+	}
+
+	var err error
+
+	if g.Width, err = parseDimension(result[1]); err != nil {
+		return nil, fmt.Errorf("invalid geometry %q: %v", s, err)
+	}
+
+	if g.Height, err = parseDimension(result[3]); err != nil {
+		return nil, fmt.Errorf("invalid geometry %q: %v", s, err)
+	}
+
+	if g.X, err = parseOffset(result[5]); err != nil {
+		return nil, fmt.Errorf("invalid geometry %q: %v", s, err)
+	}
+
+	if g.Y, err = parseOffset(result[6]); err != nil {
+		return nil, fmt.Errorf("invalid geometry %q: %v", s, err)
+	}
+
+	return g, nil
+}
+
+func geometryFlag(c byte) GeometryFlag {
+	for _, f := range geometryFlags {
+		if f.c == c {
+			return f.flag
+		}
+	}
+	return 0
+}
+
+func parseDimension(v string) (uint, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseUint(v, 10, 16)
+	return uint(n), err
+}
+
+func parseOffset(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.ParseInt(v, 10, 16)
+	return int(n), err
+}
+
+// String returns the canonical string representation of the geometry.
+func (g *Geometry) String() string {
+	var b strings.Builder
+
+	if g.Width != 0 {
+		b.WriteString(strconv.FormatUint(uint64(g.Width), 10))
+	}
+
+	if g.Height != 0 {
+		b.WriteByte('x')
+		b.WriteString(strconv.FormatUint(uint64(g.Height), 10))
+	}
+
+	if g.X != 0 || g.Y != 0 {
+		fmt.Fprintf(&b, "%+d%+d", g.X, g.Y)
+	}
+
+	for _, f := range geometryFlags {
+		if g.Flags&f.flag != 0 {
+			b.WriteByte(f.c)
+		}
+	}
+
+	return b.String()
+}
+
+// Size computes the new size of an image of width x height according to
+// the geometry, following ImageMagick's geometry semantics.
+func (g *Geometry) Size(width, height uint) (uint, uint) {
+	if width == 0 || height == 0 {
+		return width, height
+	}
+
+	fw, fh := float64(width), float64(height)
+	gw, gh := float64(g.Width), float64(g.Height)
+	var nw, nh float64
+
+	switch {
+	case g.Flags&GeometryPercent != 0:
+		if g.Width == 0 {
+			gw = 100
+		}
+
+		if g.Height == 0 {
+			gh = gw
+		}
+
+		nw, nh = fw*gw/100, fh*gh/100
+	case g.Flags&GeometryArea != 0:
+		area := gw
+
+		if g.Width == 0 {
+			area = gh
+		} else if g.Height != 0 {
+			area = gw * gh
+		}
+
+		scale := math.Sqrt(area / (fw * fh))
+		nw, nh = fw*scale, fh*scale
+	case g.Width == 0 && g.Height == 0:
+		nw, nh = fw, fh
+	case g.Width == 0:
+		nw, nh = fw*gh/fh, gh
+	case g.Height == 0:
+		nw, nh = gw, fh*gw/fw
+	case g.Flags&GeometryExact != 0:
+		nw, nh = gw, gh
+	default:
+		sx, sy := gw/fw, gh/fh
+		fill := g.Flags&GeometryFill != 0
+
+		if (sx < sy) != fill {
+			nw, nh = gw, fh*sx
+		} else {
+			nw, nh = fw*sy, gh
+		}
+	}
+
+	w, h := round(nw), round(nh)
+
+	// Like ImageMagick, > and < decide whether the whole resize applies
+	// rather than clamping each axis, which would distort the image.
+	if g.Flags&GeometryShrink != 0 && w >= width && h >= height {
+		return width, height
+	}
+
+	if g.Flags&GeometryEnlarge != 0 && w <= width && h <= height {
+		return width, height
+	}
+
+	return w, h
+}
+
+// Region computes the crop region of an image of width x height. Percent
+// values are relative to the image size and omitted or oversized values
+// are clamped to the image.
+func (g *Geometry) Region(width, height uint) (uint, uint) {
+	w, h := g.Width, g.Height
+
+	if g.Flags&GeometryPercent != 0 {
+		if w == 0 {
+			w = 100
+		}
+
+		if h == 0 {
+			h = w
+		}
+
+		w, h = round(float64(width*w)/100), round(float64(height*h)/100)
+	}
+
+	if w == 0 || w > width {
+		w = width
+	}
+
+	if h == 0 || h > height {
+		h = height
+	}
+
+	return w, h
+}
+
+func round(v float64) uint {
+	return maxUint(1, uint(math.Floor(v+0.5)))
+}
+
+func minUint(a, b uint) uint {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint(a, b uint) uint {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,120 @@
+package image
+
+import (
+	"testing"
+)
+
+type GeometryCase struct {
+	geometry string
+	w, h     uint
+	nW, nH   uint
+}
+
+func TestParseGeometry(t *testing.T) {
+	tests := []struct {
+		s, canonical string
+		g            Geometry
+	}{
+		{"640x480", "640x480", Geometry{Width: 640, Height: 480}},
+		{"640x480!", "640x480!", Geometry{Width: 640, Height: 480, Flags: GeometryExact}},
+		{"200x", "200", Geometry{Width: 200}},
+		{"200", "200", Geometry{Width: 200}},
+		{"x256", "x256", Geometry{Height: 256}},
+		{"50%", "50%", Geometry{Width: 50, Flags: GeometryPercent}},
+		{"10000@", "10000@", Geometry{Width: 10000, Flags: GeometryArea}},
+		{"640x480^>", "640x480>^", Geometry{Width: 640, Height: 480, Flags: GeometryFill | GeometryShrink}},
+		{"100x100+10-10", "100x100+10-10", Geometry{Width: 100, Height: 100, X: 10, Y: -10}},
+		{"100x100-5+5%", "100x100-5+5%", Geometry{Width: 100, Height: 100, X: -5, Y: 5, Flags: GeometryPercent}},
+		{"100x100^+10+10", "100x100+10+10^", Geometry{Width: 100, Height: 100, X: 10, Y: 10, Flags: GeometryFill}},
+		{"50%+5+5>", "50+5+5%>", Geometry{Width: 50, X: 5, Y: 5, Flags: GeometryPercent | GeometryShrink}},
+	}
+
+	for _, x := range tests {
+		g, err := ParseGeometry(x.s)
+
+		if err != nil {
+			t.Fatalf("%s: %v", x.s, err)
+		}
+
+		if *g != x.g {
+			t.Fatalf("%s: expected %+v got %+v", x.s, x.g, *g)
+		}
+
+		if s := g.String(); s != x.canonical {
+			t.Fatalf("%s: expected string %s got %s", x.s, x.canonical, s)
+		}
+	}
+
+	for _, s := range []string{"", "x", "abc", "100x100/a.png", "70000x10", "10x10+1+2+3", "1!00x2^00", "%50", "100x!100", "10>x10+5+5", "10x10+5^+5"} {
+		if _, err := ParseGeometry(s); err == nil {
+			t.Fatalf("%q: expected error", s)
+		}
+	}
+}
+
+func TestGeometrySize(t *testing.T) {
+	tests := []*GeometryCase{
+		{"200x200", 400, 200, 200, 100},
+		{"200x200", 100, 50, 200, 100},
+		{"200x200>", 100, 50, 100, 50},
+		{"200x200<", 400, 200, 400, 200},
+		{"200x200<", 100, 50, 200, 100},
+		{"200x200^", 400, 200, 400, 200},
+		{"200x200^", 100, 50, 400, 200},
+		{"640x480!", 100, 50, 640, 480},
+		{"200x", 400, 200, 200, 100},
+		{"x50", 400, 200, 100, 50},
+		{"50%", 400, 200, 200, 100},
+		{"50x25%", 400, 200, 200, 50},
+		{"x50%", 400, 200, 400, 100},
+		{"20000@", 400, 200, 200, 100},
+		{"100x200@", 400, 200, 200, 100},
+		{"300x10!>", 100, 100, 300, 10},
+		{"50x50!>", 100, 100, 50, 50},
+		{"200x200!>", 100, 100, 100, 100},
+		{"300x10!<", 100, 100, 300, 10},
+		{"50x50!<", 100, 100, 100, 100},
+		{"200x200!<", 100, 100, 200, 200},
+		{"200x200>", 400, 100, 200, 50},
+		{"200x200>", 150, 300, 100, 200},
+		{"200x200<", 150, 50, 200, 67},
+		{"200x200<", 300, 100, 300, 100},
+	}
+
+	for _, x := range tests {
+		g, err := ParseGeometry(x.geometry)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, h := g.Size(x.w, x.h)
+
+		if w != x.nW || h != x.nH {
+			t.Fatalf("%s on %dx%d: expected %dx%d got %dx%d", x.geometry, x.w, x.h, x.nW, x.nH, w, h)
+		}
+	}
+}
+
+func TestGeometryRegion(t *testing.T) {
+	tests := []*GeometryCase{
+		{"100x100", 400, 200, 100, 100},
+		{"500x100", 400, 200, 400, 100},
+		{"100x", 400, 200, 100, 200},
+		{"50%", 400, 200, 200, 100},
+	}
+
+	for _, x := range tests {
+		g, err := ParseGeometry(x.geometry)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w, h := g.Region(x.w, x.h)
+
+		if w != x.nW || h != x.nH {
+			t.Fatalf("%s on %dx%d: expected %dx%d got %dx%d", x.geometry, x.w, x.h, x.nW, x.nH, w, h)
+		}
+	}
+}
@@ -23,13 +23,20 @@
 package image
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gographics/imagick/imagick"
 	"github.com/simonz05/util/math"
 )
 
-var imgInit sync.Once
+var (
+	imgInit      sync.Once
+	imgTerminate sync.Once
+)
 
 func imgInitFn() {
 	imagick.Initialize()
@@ -39,54 +46,113 @@ func init() {
 	imgInit.Do(imgInitFn)
 }
 
+// Terminate releases the ImageMagick environment. Call it once all images
+// are destroyed, typically right before the process exits. Calls after the
+// first are no-ops.
+func Terminate() {
+	imgTerminate.Do(imagick.Terminate)
+}
+
+// ResourceLimits limits the resources used by ImageMagick. Zero values
+// keep the ImageMagick defaults.
+type ResourceLimits struct {
+	// Memory is the maximum pixel cache memory in bytes.
+	Memory int64
+	// Map is the maximum memory mapped pixel cache in bytes.
+	Map int64
+	// Disk is the maximum disk pixel cache in bytes.
+	Disk int64
+	// Threads is the maximum number of threads per operation.
+	Threads int64
+}
+
+// SetResourceLimits sets the process wide ImageMagick resource limits.
+func SetResourceLimits(l ResourceLimits) error {
+	limits := []struct {
+		t imagick.ResourceType
+		v int64
+	}{
+		{imagick.RESOURCE_MEMORY, l.Memory},
+		{imagick.RESOURCE_MAP, l.Map},
+		{imagick.RESOURCE_DISK, l.Disk},
+		{imagick.RESOURCE_THREAD, l.Threads},
+	}
+
+	for _, x := range limits {
+		if x.v <= 0 {
+			continue
+		}
+
+		if err := imagick.SetResourceLimit(x.t, x.v); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type Image struct {
 	mw        *imagick.MagickWand
 	w, h      uint
 	nW, nH    uint
 	direction string
+	// background is the color of areas added by Pad and Rotate.
+	background string
+	// limits restricts the source and output images.
+	limits Limits
+	// orientation is the EXIF orientation of the source image.
+	orientation imagick.OrientationType
 }
 
-// Create a new image from raw image source.
+// Create a new image from raw image source. The image is oriented as
+// described by its EXIF orientation.
 //
 // Example
 //
-//		im, err := NewImageFromBlob(data)
-// 		defer im.Destroy()
+//	im, err := NewImageFromBlob(data)
+//	defer im.Destroy()
 //
-// 		if err != nil {
-// 			return nil, err
-// 		}
+//	if err != nil {
+//		return nil, err
+//	}
 func NewImageFromBlob(blob []byte) (*Image, error) {
-	im := new(Image)
+	return NewImageFromBlobLimits(blob, Limits{})
+}
+
+// NewImageFromBlobLimits is like NewImageFromBlob and restricts the source
+// image and the output of operations to the limits l.
+func NewImageFromBlobLimits(blob []byte, l Limits) (*Image, error) {
+	return newImage(blob, l, true)
+}
+
+// newImage creates an image from blob restricted to the limits l, applying
+// the EXIF orientation if orient is set.
+func newImage(blob []byte, l Limits, orient bool) (*Image, error) {
+	im := &Image{limits: l}
 
 	im.mw = imagick.NewMagickWand()
+
+	if err := checkSource(blob, l); err != nil {
+		return im, err
+	}
+
 	err := im.mw.ReadImageBlob(blob)
 
 	if err != nil {
-		return im, err
+		return im, readError(err)
 	}
 
 	im.w = im.mw.GetImageWidth()
 	im.h = im.mw.GetImageHeight()
-	return im, nil
-}
+	im.orientation = im.mw.GetImageOrientation()
 
-// Ensure that width and height does contain image.
-func (im *Image) normalizeSize(width, height uint) (w, h uint) {
-	if width > height {
-		h = im.h * height / width
-		w = im.w
-	} else {
-		w = im.w * width / height
-		h = im.h
+	if orient {
+		if err = im.AutoOrient(); err != nil {
+			return im, err
+		}
 	}
 
-	if w >= width && h >= height {
-		w = width
-		h = height
-	}
-
-	return
+	return im, nil
 }
 
 // Ensure that x and y offsets does contain image.
@@ -100,34 +166,50 @@ func (im *Image) normalizeOffset(w, h uint, xOffset, yOffset int) (x int, y int)
 }
 
 // Calculate x and y offset based on gravity. ImageMagick's SetImageGravity
-// function doesn't seem to work.
+// function doesn't seem to work. The smart, entropy and attention
+// directions analyse the image, smart being an alias of attention. A focal
+// point direction centers the region on the point.
 func (im *Image) gravity(w, h uint) (x, y int) {
-	switch im.direction {
+	if strings.HasPrefix(im.direction, focalPrefix) {
+		if fp, err := ParseFocalPoint(im.direction); err == nil {
+			return fp.offset(im.w, im.h, w, h)
+		}
+	}
+
+	if im.direction == "smart" || im.direction == "attention" || im.direction == "entropy" {
+		return im.smartGravity(w, h, im.direction)
+	}
+
+	return compassOffset(im.direction, im.w, im.h, w, h)
+}
+
+// compassOffset returns the offset of a w x h region placed in a width x
+// height area by the compass direction. The region is centered for other
+// directions.
+func compassOffset(direction string, width, height, w, h uint) (x, y int) {
+	switch direction {
 	case "northwest":
 		break
 	case "north":
-		x = int((im.w / 2) - (w / 2))
+		x = int((width / 2) - (w / 2))
 	case "northeast":
-		x = int(im.w - w)
+		x = int(width - w)
 	case "west":
-		y = int((im.h / 2) - (h / 2))
+		y = int((height / 2) - (h / 2))
 	case "east":
-		x = int(im.w - w)
-		y = int((im.h / 2) - (h / 2))
+		x = int(width - w)
+		y = int((height / 2) - (h / 2))
 	case "southwest":
-		y = int(im.h - h)
+		y = int(height - h)
 	case "south":
-		x = int((im.w / 2) - (w / 2))
-		y = int(im.h - h)
+		x = int((width / 2) - (w / 2))
+		y = int(height - h)
 	case "southeast":
-		x = int(im.w - w)
-		y = int(im.h - h)
-	case "center":
-		x = int((im.w / 2) - (w / 2))
-		y = int((im.h / 2) - (h / 2))
+		x = int(width - w)
+		y = int(height - h)
 	default:
-		x = int((im.w / 2) - (w / 2))
-		y = int((im.h / 2) - (h / 2))
+		x = int((width / 2) - (w / 2))
+		y = int((height / 2) - (h / 2))
 	}
 	return
 }
@@ -172,80 +254,199 @@ func (im *Image) Height() uint {
 	return im.h
 }
 
-// SetDirection sets the crop gravity direction
+// SetDirection sets the crop gravity direction. The direction is either a
+// compass direction, a smart direction or a focal point such as fp:0.3,0.7.
 func (im *Image) SetDirection(direction string) {
 	im.direction = direction
 }
 
-// Resize formats an image according to width and height.
-func (im *Image) Resize(width, height uint) error {
-	w, h := im.normalizeSize(width, height)
+// Resize scales the image according to the geometry g. Width and height are
+// maximum values unless modified by the geometry flags.
+func (im *Image) Resize(g *Geometry) error {
+	w, h := g.Size(im.w, im.h)
+
+	if w == im.w && h == im.h {
+		return nil
+	}
+
+	if err := im.checkOutput(w, h); err != nil {
+		return err
+	}
 
 	if err := im.mw.ResizeImage(w, h, imagick.FILTER_LANCZOS, 1); err != nil {
 		return err
 	}
 
+	im.w, im.h = w, h
 	return nil
 }
 
-// Crop formats an image according to width and height.
-func (im *Image) Crop(width, height uint, x, y int) (err error) {
-	w, h := im.normalizeSize(width, height)
-	x, y = im.normalizeOffset(w, h, x, y)
+// Crop cuts out the region described by the geometry g. The region is
+// positioned by gravity direction and then moved by the geometry offsets.
+func (im *Image) Crop(g *Geometry) (err error) {
+	w, h := g.Region(im.w, im.h)
+	x, y := im.normalizeOffset(w, h, g.X, g.Y)
 
-	if err = im.mw.CropImage(w, h, x, y); err != nil {
+	if err = im.crop(w, h, x, y); err != nil {
 		return
 	}
 
-	//if err = im.mw.ResetImagePage("0x0"); err != nil {
-	//	return
-	//}
-
+	im.w, im.h = w, h
 	return
 }
 
-// Thumbnail fits an image to a given size. It first calls crop, then resize.
-func (im *Image) Thumbnail(width, height uint, x, y int) (err error) {
-	w, h := im.normalizeSize(width, height)
-	cw, ch := im.cropSize(w, h)
-	x, y = im.normalizeOffset(cw, ch, x, y)
+// crop cuts out a region and resets the virtual canvas, which CropImage
+// leaves offset by the region. Later operations would otherwise position
+// their regions relative to the stale canvas.
+func (im *Image) crop(w, h uint, x, y int) error {
+	if err := im.mw.CropImage(w, h, x, y); err != nil {
+		return err
+	}
+
+	return im.mw.ResetImagePage("0x0")
+}
+
+// Thumbnail fits an image to a given size. It first crops the image to the
+// aspect ratio of the geometry, then resizes it. Geometries without both
+// width and height, or with the %, @ or ! flags, are passed on to Resize.
+func (im *Image) Thumbnail(g *Geometry) (err error) {
+	if thumbnailResizes(g) {
+		return im.Resize(g)
+	}
+
+	if err = im.checkOutput(im.ThumbnailSize(g)); err != nil {
+		return
+	}
+
+	cw, ch := im.cropSize(g.Width, g.Height)
+	x, y := im.normalizeOffset(cw, ch, g.X, g.Y)
 
-	if err = im.mw.CropImage(cw, ch, x, y); err != nil {
+	if err = im.crop(cw, ch, x, y); err != nil {
 		return
 	}
 
-	if err = im.mw.ResizeImage(w, h, imagick.FILTER_LANCZOS, 1); err != nil {
+	im.w, im.h = cw, ch
+
+	if thumbnailKeepsCrop(g, cw) {
 		return
 	}
 
+	if err = im.mw.ResizeImage(g.Width, g.Height, imagick.FILTER_LANCZOS, 1); err != nil {
+		return
+	}
+
+	im.w, im.h = g.Width, g.Height
 	return
 }
 
+// thumbnailResizes reports whether Thumbnail passes g on to Resize.
+func thumbnailResizes(g *Geometry) bool {
+	return g.Width == 0 || g.Height == 0 || g.Flags&(GeometryPercent|GeometryArea|GeometryExact) != 0
+}
+
+// thumbnailKeepsCrop reports whether Thumbnail skips resizing a region
+// cropped to width cw, as > never enlarges and < never shrinks it.
+func thumbnailKeepsCrop(g *Geometry, cw uint) bool {
+	return g.Flags&GeometryShrink != 0 && g.Width > cw || g.Flags&GeometryEnlarge != 0 && g.Width < cw
+}
+
+// Sharpen sharpens the image using a Gaussian operator of the given radius
+// and standard deviation. A zero radius selects a suitable radius.
+func (im *Image) Sharpen(radius, sigma float64) error {
+	return im.mw.SharpenImage(radius, sigma)
+}
+
 // Set the compression quality (high quality = low compression)
 func (im *Image) Compress(level uint) error {
 	return im.mw.SetImageCompressionQuality(level)
 }
 
-// Resize formats an image according to width and height. It does not preserve
-// the aspect ratio of the image.
-func Resize(data []byte, width, height uint) ([]byte, error) {
-	im, err := NewImageFromBlob(data)
+// Format returns the current format of the image.
+func (im *Image) Format() Format {
+	return Format(strings.ToLower(im.mw.GetImageFormat()))
+}
+
+// Encode returns the image encoded according to opt. A nil opt encodes the
+// image in its current format with default settings.
+func (im *Image) Encode(opt *EncodeOptions) ([]byte, error) {
+	if opt == nil {
+		return im.mw.GetImageBlob(), nil
+	}
+
+	format := im.Format()
+
+	if opt.Format != "" && opt.Format != format {
+		if err := im.mw.SetImageFormat(strings.ToUpper(string(opt.Format))); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+		}
+
+		format = opt.Format
+	}
+
+	// Without flattening, transparent areas turn black in formats lacking
+	// an alpha channel.
+	if opt.Background != "" || !format.Alpha() {
+		if err := im.flatten(opt.background(format), opt.defaultBackground(), format); err != nil {
+			return nil, err
+		}
+	}
+
+	// ImageMagick reads the PNG quality as compression level and filter,
+	// so quality is only applied to lossy formats.
+	if opt.Quality > 0 && format.Lossy() {
+		if err := im.Compress(opt.Quality); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Compression > 0 && format == FormatPNG {
+		if err := im.mw.SetOption("png:compression-level", strconv.Itoa(int(opt.Compression))); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Progressive {
+		if err := im.mw.SetImageInterlaceScheme(imagick.INTERLACE_PLANE); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Subsampling != "" {
+		if err := im.mw.SetOption("jpeg:sampling-factor", opt.Subsampling); err != nil {
+			return nil, err
+		}
+	}
+
+	if opt.Strip {
+		if err := im.mw.StripImage(); err != nil {
+			return nil, err
+		}
+	}
+
+	return im.mw.GetImageBlob(), nil
+}
+
+// Resize scales an image according to the geometry g and encodes it
+// according to opt.
+func Resize(data []byte, g *Geometry, opt *EncodeOptions) ([]byte, error) {
+	im, err := decode(data, opt)
 	defer im.Destroy()
 
 	if err != nil {
 		return nil, err
 	}
 
-	if err = im.Resize(width, height); err != nil {
+	if err = im.Resize(g); err != nil {
 		return nil, err
 	}
 
-	return im.mw.GetImageBlob(), nil
+	return im.Encode(opt)
 }
 
-// Crop formats an image according to width and height.
-func Crop(data []byte, width, height uint, x, y int, direction string) ([]byte, error) {
-	im, err := NewImageFromBlob(data)
+// Crop cuts out the region described by the geometry g and encodes it
+// according to opt.
+func Crop(data []byte, g *Geometry, direction string, opt *EncodeOptions) ([]byte, error) {
+	im, err := decode(data, opt)
 	defer im.Destroy()
 
 	if err != nil {
@@ -254,16 +455,17 @@ func Crop(data []byte, width, height uint, x, y int, direction string) ([]byte,
 
 	im.direction = direction
 
-	if err = im.Crop(width, height, x, y); err != nil {
+	if err = im.Crop(g); err != nil {
 		return nil, err
 	}
 
-	return im.mw.GetImageBlob(), nil
+	return im.Encode(opt)
 }
 
 // Thumbnail fits an image to a given size. It first calls Crop, then Resize.
-func Thumbnail(data []byte, width, height uint, direction string) ([]byte, error) {
-	im, err := NewImageFromBlob(data)
+// The result is encoded according to opt.
+func Thumbnail(data []byte, g *Geometry, direction string, opt *EncodeOptions) ([]byte, error) {
+	im, err := decode(data, opt)
 	defer im.Destroy()
 
 	if err != nil {
@@ -272,9 +474,70 @@ func Thumbnail(data []byte, width, height uint, direction string) ([]byte, error
 
 	im.SetDirection(direction)
 
-	if err = im.Thumbnail(width, height, 0, 0); err != nil {
+	if err = im.Thumbnail(g); err != nil {
 		return nil, err
 	}
 
-	return im.mw.GetImageBlob(), nil
+	return im.Encode(opt)
+}
+
+// decode decodes data within the limits and orientation options of opt,
+// which may be nil.
+func decode(data []byte, opt *EncodeOptions) (*Image, error) {
+	if opt == nil {
+		return NewImageFromBlob(data)
+	}
+
+	return newImage(data, opt.Limits, !opt.KeepOrientation)
+}
+
+// Operation is a single transformation step of a pipeline.
+type Operation func(im *Image) error
+
+// Stats records the time spent in the phases of a pipeline.
+type Stats struct {
+	Decode    time.Duration
+	Transform time.Duration
+	Encode    time.Duration
+}
+
+// Pipeline applies ops to an image in order and encodes the result
+// according to opt. The image is decoded and encoded only once.
+func Pipeline(data []byte, ops []Operation, opt *EncodeOptions) ([]byte, error) {
+	return PipelineStats(data, ops, opt, nil)
+}
+
+// PipelineStats is like Pipeline and records the phase timings in st if
+// st is non-nil.
+func PipelineStats(data []byte, ops []Operation, opt *EncodeOptions, st *Stats) ([]byte, error) {
+	if st == nil {
+		st = new(Stats)
+	}
+
+	start := time.Now()
+	im, err := decode(data, opt)
+	defer im.Destroy()
+	st.Decode = time.Since(start)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if opt != nil {
+		im.SetBackground(opt.background(im.Format()))
+	}
+
+	start = time.Now()
+
+	for _, op := range ops {
+		if err = op(im); err != nil {
+			return nil, err
+		}
+	}
+
+	st.Transform = time.Since(start)
+	start = time.Now()
+	blob, err := im.Encode(opt)
+	st.Encode = time.Since(start)
+	return blob, err
 }
@@ -1,6 +1,7 @@
 package image
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -47,7 +48,7 @@ func TestResize(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		after, err := Resize(before, x.w, x.h)
+		after, err := Resize(before, &Geometry{Width: x.w, Height: x.h}, nil)
 
 		if err != nil {
 			t.Fatal(err)
@@ -73,6 +74,8 @@ func TestThumbnail(t *testing.T) {
 		{"fixture/circle.png", 400, 200, "north"},
 		{"fixture/circle.png", 200, 400, "north"},
 		{"fixture/circle.png", 800, 200, "center"},
+		{"fixture/circle.png", 400, 200, "smart"},
+		{"fixture/gopher-1.jpg", 200, 200, "entropy"},
 	}
 
 	if err := os.Mkdir("test-out", os.ModeDir|os.ModePerm); os.IsNotExist(err) {
@@ -86,7 +89,7 @@ func TestThumbnail(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		after, err := Thumbnail(before, x.w, x.h, x.direction)
+		after, err := Thumbnail(before, &Geometry{Width: x.w, Height: x.h}, x.direction, nil)
 
 		if err != nil {
 			t.Fatal(err)
@@ -113,6 +116,9 @@ func TestCrop(t *testing.T) {
 		{"fixture/circle.png", 30, 150, 0, 0, "northeast"},
 		{"fixture/circle.png", 30, 150, 20, 0, "northeast"},
 		{"fixture/circle.png", 30, 150, 0, 0, "northeast"}, // should be equal as prev
+		{"fixture/gopher-1.jpg", 200, 100, 0, 0, "smart"},
+		{"fixture/gopher-1.jpg", 200, 100, 0, 0, "entropy"},
+		{"fixture/gopher-1.jpg", 200, 100, 0, 0, "attention"},
 	}
 
 	if err := os.Mkdir("test-out", os.ModeDir|os.ModePerm); os.IsNotExist(err) {
@@ -126,7 +132,7 @@ func TestCrop(t *testing.T) {
 			t.Fatal(err)
 		}
 
-		after, err := Crop(before, x.w, x.h, x.x, x.y, x.direction)
+		after, err := Crop(before, &Geometry{Width: x.w, Height: x.h, X: x.x, Y: x.y}, x.direction, nil)
 
 		if err != nil {
 			t.Fatal(err)
@@ -140,3 +146,725 @@ func TestCrop(t *testing.T) {
 		}
 	}
 }
+
+func TestLimits(t *testing.T) {
+	imagick.Initialize()
+	defer imagick.Terminate()
+
+	data, err := ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		limits Limits
+		err    error
+	}{
+		{Limits{MaxBytes: 10}, ErrTooLarge},
+		{Limits{MaxPixels: 10}, ErrTooLarge},
+		{Limits{MaxWidth: 100, MaxHeight: 100}, ErrOutputTooLarge},
+		{Limits{MaxWidth: 1000, MaxHeight: 1000}, nil},
+	}
+
+	for i, x := range tests {
+		_, err := Resize(data, &Geometry{Width: 200, Height: 200, Flags: GeometryExact}, &EncodeOptions{Limits: x.limits})
+
+		if !errors.Is(err, x.err) || (x.err == nil && err != nil) {
+			t.Fatalf("%d: expected %v got %v", i, x.err, err)
+		}
+	}
+
+	// The limits apply to the output, not to the requested geometry.
+	thumbnails := []struct {
+		geometry string
+		err      error
+	}{
+		{"800x800>", nil},
+		{"300x300<", nil},
+		{"600x600", ErrOutputTooLarge},
+		{"800x800<", ErrOutputTooLarge},
+	}
+
+	for _, x := range thumbnails {
+		g, err := ParseGeometry(x.geometry)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = Thumbnail(data, g, "", &EncodeOptions{Limits: Limits{MaxWidth: 500, MaxHeight: 500}})
+
+		if !errors.Is(err, x.err) || (x.err == nil && err != nil) {
+			t.Errorf("%s: expected %v got %v", x.geometry, x.err, err)
+		}
+	}
+}
+
+func TestReadError(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		data        []byte
+		unsupported bool
+	}{
+		{data[:len(data)/2], false},
+		{[]byte("not an image"), true},
+	}
+
+	for _, tt := range tests {
+		for _, read := range []func([]byte) (*Image, error){
+			NewImageFromBlob,
+			func(b []byte) (*Image, error) { return PingImageFromBlob(b, nil) },
+		} {
+			im, err := read(tt.data)
+			im.Destroy()
+
+			if err == nil && tt.unsupported {
+				t.Errorf("%d bytes: expected error", len(tt.data))
+			}
+
+			if err != nil && errors.Is(err, ErrUnsupportedFormat) != tt.unsupported {
+				t.Errorf("%d bytes: expected unsupported %t got %v", len(tt.data), tt.unsupported, err)
+			}
+		}
+	}
+
+	if _, err := NewImageFromBlob(data[:len(data)/2]); err == nil {
+		t.Error("expected error decoding a truncated image")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if err := Probe(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := NewImageFromBlob(data)
+	defer im.Destroy()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := im.Info()
+
+	if info.Width != 400 || info.Height != 400 || info.Format != FormatPNG || info.Frames != 1 || info.ColorSpace == "" {
+		t.Fatalf("unexpected info %+v", info)
+	}
+
+	tests := []struct {
+		size     func(*Image, *Geometry) (uint, uint)
+		geometry string
+		w, h     uint
+	}{
+		{(*Image).ThumbnailSize, "200x100", 200, 100},
+		{(*Image).ThumbnailSize, "800x800>", 400, 400},
+		{(*Image).ThumbnailSize, "50%", 200, 200},
+		{(*Image).ResizeSize, "200x", 200, 200},
+		{(*Image).CropSize, "100x150", 100, 150},
+	}
+
+	for _, tt := range tests {
+		g, err := ParseGeometry(tt.geometry)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w, h := tt.size(im, g); w != tt.w || h != tt.h {
+			t.Errorf("%s: expected %dx%d got %dx%d", tt.geometry, tt.w, tt.h, w, h)
+		}
+	}
+}
+
+func TestPingImageFromBlob(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/orient-6.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opt  *EncodeOptions
+		w, h uint
+		err  error
+	}{
+		{nil, 32, 64, nil},
+		{&EncodeOptions{KeepOrientation: true}, 64, 32, nil},
+		{&EncodeOptions{Limits: Limits{MaxBytes: 10}}, 0, 0, ErrTooLarge},
+		{&EncodeOptions{Limits: Limits{MaxPixels: 100}}, 0, 0, ErrTooLarge},
+	}
+
+	for _, tt := range tests {
+		im, err := PingImageFromBlob(data, tt.opt)
+
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("%+v: expected %v got %v", tt.opt, tt.err, err)
+		}
+
+		if err == nil {
+			info := im.Info()
+
+			if info.Width != tt.w || info.Height != tt.h || info.Orientation != 6 || info.Format != FormatJPEG {
+				t.Errorf("%+v: unexpected info %+v", tt.opt, info)
+			}
+		}
+
+		im.Destroy()
+	}
+}
+
+func TestAutoOrient(t *testing.T) {
+	// The fixture is stored as 64x32 with a white block at x 16-31, y 0-15
+	// and has the EXIF orientation right-top.
+	data, err := ioutil.ReadFile("fixture/orient-6.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		orientation imagick.OrientationType
+		w, h        uint
+		// x, y is a pixel of the white block once oriented.
+		x, y int
+	}{
+		{imagick.ORIENTATION_UNDEFINED, 64, 32, 24, 8},
+		{imagick.ORIENTATION_TOP_LEFT, 64, 32, 24, 8},
+		{imagick.ORIENTATION_TOP_RIGHT, 64, 32, 39, 8},
+		{imagick.ORIENTATION_BOTTOM_RIGHT, 64, 32, 39, 23},
+		{imagick.ORIENTATION_BOTTOM_LEFT, 64, 32, 24, 23},
+		{imagick.ORIENTATION_LEFT_TOP, 32, 64, 8, 24},
+		{imagick.ORIENTATION_RIGHT_TOP, 32, 64, 23, 24},
+		{imagick.ORIENTATION_RIGHT_BOTTOM, 32, 64, 23, 39},
+		{imagick.ORIENTATION_LEFT_BOTTOM, 32, 64, 8, 39},
+	}
+
+	for _, tt := range tests {
+		im, err := newImage(data, Limits{}, false)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im.mw.SetImageOrientation(tt.orientation)
+
+		if err := im.AutoOrient(); err != nil {
+			t.Fatal(err)
+		}
+
+		if im.Width() != tt.w || im.Height() != tt.h {
+			t.Errorf("orientation %d: expected %dx%d got %dx%d", tt.orientation, tt.w, tt.h, im.Width(), im.Height())
+		}
+
+		// The mirrored pixel tells a flop from a missing rotation.
+		if l := luma(t, im, tt.x, tt.y); l < 0.5 {
+			t.Errorf("orientation %d: expected white at %d,%d got %.2f", tt.orientation, tt.x, tt.y, l)
+		}
+
+		if l := luma(t, im, int(tt.w)-1-tt.x, tt.y); l > 0.5 {
+			t.Errorf("orientation %d: expected black at %d,%d got %.2f", tt.orientation, int(tt.w)-1-tt.x, tt.y, l)
+		}
+
+		if tt.orientation != imagick.ORIENTATION_UNDEFINED {
+			if o := encodedOrientation(t, im); o != imagick.ORIENTATION_TOP_LEFT {
+				t.Errorf("orientation %d: expected encoded top-left got %d", tt.orientation, o)
+			}
+		}
+
+		im.Destroy()
+	}
+
+	im, err := NewImageFromBlob(data)
+	defer im.Destroy()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if im.Width() != 32 || im.Height() != 64 || luma(t, im, 23, 24) < 0.5 {
+		t.Errorf("expected oriented 32x64 image got %dx%d", im.Width(), im.Height())
+	}
+
+	kept, err := Pipeline(data, nil, &EncodeOptions{KeepOrientation: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	im, err = NewImageFromBlob(kept)
+	defer im.Destroy()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if im.orientation != imagick.ORIENTATION_RIGHT_TOP {
+		t.Errorf("expected the orientation to be kept got %d", im.orientation)
+	}
+}
+
+// luma returns the luminance of the pixel at x, y of im.
+func luma(t *testing.T, im *Image, x, y int) float64 {
+	pw, err := im.mw.GetImagePixelColor(x, y)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer pw.Destroy()
+	return 0.299*pw.GetRed() + 0.587*pw.GetGreen() + 0.114*pw.GetBlue()
+}
+
+// encodedOrientation returns the orientation of im read back from its
+// encoded blob.
+func encodedOrientation(t *testing.T, im *Image) imagick.OrientationType {
+	blob, err := im.Encode(&EncodeOptions{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	if err := mw.ReadImageBlob(blob); err != nil {
+		t.Fatal(err)
+	}
+
+	return mw.GetImageOrientation()
+}
+
+func TestSmartWindow(t *testing.T) {
+	// A flat gray 40x10 image with a detailed red block at x 28 to 35.
+	p := &pixelMap{40, 10, make([]byte, 3*40*10)}
+
+	for i := range p.rgb {
+		p.rgb[i] = 128
+	}
+
+	for y := 0; y < 10; y++ {
+		for x := 28; x < 36; x++ {
+			i := 3 * (y*40 + x)
+			p.rgb[i], p.rgb[i+1], p.rgb[i+2] = 200, byte(20*((x+y)%4)), 40
+		}
+	}
+
+	flat := &pixelMap{40, 10, make([]byte, 3*40*10)}
+
+	tests := []struct {
+		name   string
+		p      *pixelMap
+		window func(*pixelMap, int, int) (int, int)
+		minX   int
+		maxX   int
+	}{
+		{"attention", p, attentionWindow, 26, 28},
+		{"entropy", p, entropyWindow, 26, 28},
+		{"attention flat", flat, attentionWindow, 15, 15},
+		{"entropy flat", flat, entropyWindow, 15, 15},
+	}
+
+	for _, tt := range tests {
+		x, y := tt.window(tt.p, 10, 10)
+
+		if x < tt.minX || x > tt.maxX || y != 0 {
+			t.Errorf("%s: expected x in [%d, %d] got %d,%d", tt.name, tt.minX, tt.maxX, x, y)
+		}
+	}
+}
+
+func TestFocalPoint(t *testing.T) {
+	tests := []struct {
+		direction string
+		w, h      uint
+		x, y      int
+	}{
+		{"fp:0.5,0.5", 100, 100, 150, 150},
+		{"fp:0,0", 100, 100, 0, 0},
+		{"fp:1,1", 100, 100, 300, 300},
+		{"fp:0.3,0.7", 100, 200, 70, 180},
+		{"fp:0.3,0.7", 400, 100, 0, 230},
+		{"fp:0.9,0.05", 100, 100, 300, 0},
+	}
+
+	for _, tt := range tests {
+		im := &Image{w: 400, h: 400, direction: tt.direction}
+
+		if x, y := im.normalizeOffset(tt.w, tt.h, 0, 0); x != tt.x || y != tt.y {
+			t.Errorf("%s %dx%d: expected %d,%d got %d,%d", tt.direction, tt.w, tt.h, tt.x, tt.y, x, y)
+		}
+	}
+
+	for _, v := range []string{"", "0.5", "fp:0.5", "fp:a,0", "fp:0,1.5", "fp:-0.1,0", "fp:NaN,0.5", "fp:0.5,nan"} {
+		if _, err := ParseFocalPoint(v); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+
+	if fp, err := ParseFocalPoint("0.250,1"); err != nil || fp.String() != "fp:0.25,1" {
+		t.Errorf("unexpected focal point %v %v", fp, err)
+	}
+
+	if x, y := (&FocalPoint{0, 0.001}).offset(400, 400, 1, 1); x != 0 || y != 0 {
+		t.Errorf("expected offset 0,0 got %d,%d", x, y)
+	}
+}
+
+func TestPad(t *testing.T) {
+	tests := []struct {
+		filename  string
+		geometry  string
+		direction string
+		w, h      uint
+	}{
+		{"fixture/circle.png", "300x200", "", 300, 200},
+		{"fixture/circle.png", "300x200", "west", 300, 200},
+		{"fixture/circle.png", "800x600", "southeast", 800, 600},
+		{"fixture/circle.png", "200x400^", "north", 200, 400},
+		{"fixture/circle.png", "200", "", 200, 200},
+		{"fixture/circle.png", "50%", "", 200, 200},
+		{"fixture/gopher-1.jpg", "100x100", "", 100, 100},
+		{"fixture/gopher-1.jpg", "400x400>", "", 400, 400},
+	}
+
+	for _, tt := range tests {
+		data, err := ioutil.ReadFile(tt.filename)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im, err := NewImageFromBlob(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		g, _ := ParseGeometry(tt.geometry)
+		im.SetDirection(tt.direction)
+
+		if w, h := im.PadSize(g); w != tt.w || h != tt.h {
+			t.Errorf("%s %s: expected size %dx%d got %dx%d", tt.filename, tt.geometry, tt.w, tt.h, w, h)
+		}
+
+		if err := im.Pad(g); err != nil {
+			t.Fatalf("%s %s: %v", tt.filename, tt.geometry, err)
+		}
+
+		if im.Width() != tt.w || im.Height() != tt.h {
+			t.Errorf("%s %s: expected %dx%d got %dx%d", tt.filename, tt.geometry, tt.w, tt.h, im.Width(), im.Height())
+		}
+
+		im.Destroy()
+	}
+}
+
+func TestEncodeOptions(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/gopher-1.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// encode encodes the fixture with a comment according to opt and reads
+	// the result back.
+	encode := func(opt *EncodeOptions) *imagick.MagickWand {
+		im, err := NewImageFromBlob(data)
+		defer im.Destroy()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		im.mw.SetImageProperty("comment", "imgfilter")
+		blob, err := im.Encode(opt)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mw := imagick.NewMagickWand()
+
+		if err := mw.ReadImageBlob(blob); err != nil {
+			t.Fatal(err)
+		}
+
+		return mw
+	}
+
+	mw := encode(&EncodeOptions{Quality: 50})
+
+	if q := mw.GetImageCompressionQuality(); q != 50 {
+		t.Errorf("expected quality 50 got %d", q)
+	}
+
+	if i := mw.GetImageInterlaceScheme(); i != imagick.INTERLACE_NO && i != imagick.INTERLACE_UNDEFINED {
+		t.Errorf("expected baseline JPEG got interlace %d", i)
+	}
+
+	if c := mw.GetImageProperty("comment"); c != "imgfilter" {
+		t.Errorf("expected comment got %q", c)
+	}
+
+	mw.Destroy()
+	mw = encode(&EncodeOptions{Progressive: true, Subsampling: "4:4:4", Strip: true})
+
+	if i := mw.GetImageInterlaceScheme(); i == imagick.INTERLACE_NO || i == imagick.INTERLACE_UNDEFINED {
+		t.Errorf("expected progressive JPEG got interlace %d", i)
+	}
+
+	if sf := mw.GetImageProperty("jpeg:sampling-factor"); sf != "1x1,1x1,1x1" {
+		t.Errorf("expected 4:4:4 sampling got %q", sf)
+	}
+
+	if c := mw.GetImageProperty("comment"); c != "" {
+		t.Errorf("expected stripped comment got %q", c)
+	}
+
+	mw.Destroy()
+
+	data, err = ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sizes []int
+
+	for _, c := range []uint{1, 9} {
+		blob, err := Pipeline(data, nil, &EncodeOptions{Compression: c})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		sizes = append(sizes, len(blob))
+	}
+
+	if sizes[1] >= sizes[0] {
+		t.Errorf("expected compression 9 smaller than 1 got %d and %d bytes", sizes[1], sizes[0])
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	tests := map[string]string{
+		"ff0000":     "#ff0000",
+		"#FFF":       "#fff",
+		"ff000080":   "#ff000080",
+		"White":      "white",
+		"none":       "none",
+		"abc":        "#abc",
+		"":           "",
+		"ff00":       "#ff00",
+		"#ff00000":   "",
+		"red1":       "",
+		"rgb(0,0,0)": "",
+		"notacolor":  "",
+	}
+
+	for v, expected := range tests {
+		c, err := ParseColor(v)
+
+		if expected == "" && err == nil || expected != "" && c != expected {
+			t.Errorf("%q: expected %q got %q %v", v, expected, c, err)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opt   EncodeOptions
+		alpha bool
+	}{
+		{EncodeOptions{}, true},
+		{EncodeOptions{Background: "none"}, true},
+		{EncodeOptions{Background: "#ff0000"}, false},
+		{EncodeOptions{Format: FormatJPEG}, false},
+		{EncodeOptions{Format: FormatJPEG, Background: "none"}, false},
+		{EncodeOptions{Format: FormatJPEG, DefaultBackground: "black"}, false},
+		{EncodeOptions{Format: FormatWebP}, true},
+	}
+
+	for _, tt := range tests {
+		im, err := NewImageFromBlob(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !im.mw.GetImageAlphaChannel() {
+			t.Skip("fixture has no alpha channel")
+		}
+
+		if _, err := im.Encode(&tt.opt); err != nil {
+			t.Fatalf("%s: %v", &tt.opt, err)
+		}
+
+		if alpha := im.mw.GetImageAlphaChannel(); alpha != tt.alpha {
+			t.Errorf("%s: expected alpha %t got %t", &tt.opt, tt.alpha, alpha)
+		}
+
+		im.Destroy()
+	}
+}
+
+func TestRotate(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/gopher-1.jpg")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		degrees float64
+		w, h    uint
+	}{
+		{0, 232, 320},
+		{360, 232, 320},
+		{90, 320, 232},
+		{-90, 320, 232},
+		{180, 232, 320},
+		{450, 320, 232},
+		{45, 390, 390},
+	}
+
+	for _, tt := range tests {
+		im, err := NewImageFromBlob(data)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := im.Rotate(tt.degrees); err != nil {
+			t.Fatalf("%g: %v", tt.degrees, err)
+		}
+
+		// ImageMagick may round the size of arbitrary rotations up.
+		if w, h := im.Width(), im.Height(); w < tt.w || w > tt.w+1 || h < tt.h || h > tt.h+1 {
+			t.Errorf("%g: expected %dx%d got %dx%d", tt.degrees, tt.w, tt.h, w, h)
+		}
+
+		if err := im.Flip(); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := im.Flop(); err != nil {
+			t.Fatal(err)
+		}
+
+		im.Destroy()
+	}
+
+	im, err := NewImageFromBlob(data)
+	defer im.Destroy()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := im.Rotate(30); err != nil {
+		t.Fatal(err)
+	}
+
+	w, h := im.Width(), im.Height()
+
+	if err := im.Crop(&Geometry{Width: w, Height: h}); err != nil {
+		t.Fatal(err)
+	}
+
+	if mw, mh := im.mw.GetImageWidth(), im.mw.GetImageHeight(); mw != w || mh != h {
+		t.Errorf("crop after rotate: expected %dx%d got %dx%d", w, h, mw, mh)
+	}
+
+	for _, degrees := range []float64{90, 30} {
+		im, err := NewImageFromBlobLimits(data, Limits{MaxWidth: 300})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := im.Rotate(degrees); !errors.Is(err, ErrOutputTooLarge) {
+			t.Errorf("%g: expected ErrOutputTooLarge got %v", degrees, err)
+		}
+
+		im.Destroy()
+	}
+}
+
+func TestCropChain(t *testing.T) {
+	data, err := ioutil.ReadFile("fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crop := func(s string) Operation {
+		g, err := ParseGeometry(s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return func(im *Image) error { return im.Crop(g) }
+	}
+
+	thumbnail := func(s string) Operation {
+		g, err := ParseGeometry(s)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return func(im *Image) error { return im.Thumbnail(g) }
+	}
+
+	tests := []struct {
+		ops  []Operation
+		w, h uint
+	}{
+		{[]Operation{crop("100x100+50+50"), crop("10x10")}, 10, 10},
+		{[]Operation{crop("100x100+300+300"), crop("50x50+10+10")}, 50, 50},
+		{[]Operation{thumbnail("200x100^"), crop("20x20+180+80")}, 20, 20},
+		{[]Operation{crop("200x200+200+0"), thumbnail("50x100^")}, 50, 100},
+	}
+
+	for i, tt := range tests {
+		out, err := Pipeline(data, tt.ops, &EncodeOptions{})
+
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		im, err := NewImageFromBlob(out)
+
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+
+		if im.Width() != tt.w || im.Height() != tt.h {
+			t.Errorf("%d: expected %dx%d got %dx%d", i, tt.w, tt.h, im.Width(), im.Height())
+		}
+
+		im.Destroy()
+	}
+}
@@ -0,0 +1,121 @@
+package image
+
+import (
+	"github.com/gographics/imagick/imagick"
+)
+
+var colorSpaces = map[imagick.ColorspaceType]string{
+	imagick.COLORSPACE_RGB:   "rgb",
+	imagick.COLORSPACE_SRGB:  "srgb",
+	imagick.COLORSPACE_GRAY:  "gray",
+	imagick.COLORSPACE_CMYK:  "cmyk",
+	imagick.COLORSPACE_LAB:   "lab",
+	imagick.COLORSPACE_XYZ:   "xyz",
+	imagick.COLORSPACE_YCBCR: "ycbcr",
+	imagick.COLORSPACE_YUV:   "yuv",
+	imagick.COLORSPACE_HSB:   "hsb",
+	imagick.COLORSPACE_HSL:   "hsl",
+}
+
+// Info describes an image.
+type Info struct {
+	Width  uint
+	Height uint
+	Format Format
+	Alpha  bool
+	// Orientation is the EXIF orientation of the source image, 1-8, or 0
+	// if undefined. Width and Height are those of the oriented image unless
+	// the orientation is kept.
+	Orientation int
+	// ColorSpace is the color space name, e.g. srgb, or unknown.
+	ColorSpace string
+	// Profile is the description of the ICC color profile, if any.
+	Profile string
+	// Frames is the number of frames of animated images, otherwise 1.
+	Frames uint
+}
+
+// PingImageFromBlob reads the header of the raw image source within the
+// limits and orientation options of opt, which may be nil. The pixels are
+// not decoded, so only Info, Destroy and the size methods such as
+// ResizeSize may be used. Width and Height are those of the oriented image
+// unless the orientation is kept.
+func PingImageFromBlob(blob []byte, opt *EncodeOptions) (*Image, error) {
+	if opt == nil {
+		opt = &EncodeOptions{}
+	}
+
+	im := &Image{limits: opt.Limits}
+	im.mw = imagick.NewMagickWand()
+
+	if err := checkBytes(blob, opt.Limits); err != nil {
+		return im, err
+	}
+
+	if err := im.mw.PingImageBlob(blob); err != nil {
+		return im, readError(err)
+	}
+
+	if err := checkPixels(im.mw, opt.Limits); err != nil {
+		return im, err
+	}
+
+	im.w = im.mw.GetImageWidth()
+	im.h = im.mw.GetImageHeight()
+	im.orientation = im.mw.GetImageOrientation()
+
+	if !opt.KeepOrientation && im.orientation >= imagick.ORIENTATION_LEFT_TOP {
+		im.w, im.h = im.h, im.w
+	}
+
+	return im, nil
+}
+
+// Info returns a description of the image.
+func (im *Image) Info() *Info {
+	info := &Info{
+		Width:       im.w,
+		Height:      im.h,
+		Format:      im.Format(),
+		Alpha:       im.mw.GetImageAlphaChannel(),
+		Orientation: int(im.orientation),
+		ColorSpace:  colorSpaces[im.mw.GetImageColorspace()],
+		Profile:     im.mw.GetImageProperty("icc:description"),
+		Frames:      im.mw.GetNumberImages(),
+	}
+
+	if info.ColorSpace == "" {
+		info.ColorSpace = "unknown"
+	}
+
+	if info.Profile == "" && len(im.mw.GetImageProfiles("icc")) > 0 {
+		info.Profile = "icc"
+	}
+
+	return info
+}
+
+// ResizeSize returns the size of the image after Resize(g).
+func (im *Image) ResizeSize(g *Geometry) (uint, uint) {
+	return g.Size(im.w, im.h)
+}
+
+// CropSize returns the size of the image after Crop(g).
+func (im *Image) CropSize(g *Geometry) (uint, uint) {
+	return g.Region(im.w, im.h)
+}
+
+// ThumbnailSize returns the size of the image after Thumbnail(g).
+func (im *Image) ThumbnailSize(g *Geometry) (uint, uint) {
+	if thumbnailResizes(g) {
+		return g.Size(im.w, im.h)
+	}
+
+	cw, ch := im.cropSize(g.Width, g.Height)
+
+	if thumbnailKeepsCrop(g, cw) {
+		return cw, ch
+	}
+
+	return g.Width, g.Height
+}
@@ -0,0 +1,84 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+var (
+	// ErrTooLarge is returned for source images exceeding the limits.
+	ErrTooLarge = errors.New("image: source too large")
+	// ErrOutputTooLarge is returned for operations which would produce
+	// an image exceeding the limits.
+	ErrOutputTooLarge = errors.New("image: output too large")
+)
+
+// Limits restricts the images processed by an Image. Zero values are
+// unlimited.
+type Limits struct {
+	// MaxBytes is the maximum size of an encoded source image.
+	MaxBytes int64
+	// MaxPixels is the maximum number of pixels of a source image,
+	// counting all frames.
+	MaxPixels uint64
+	// MaxWidth is the maximum width of an output image.
+	MaxWidth uint
+	// MaxHeight is the maximum height of an output image.
+	MaxHeight uint
+}
+
+// checkSource checks blob against the source limits. The image header is
+// pinged so the pixels are not decoded.
+func checkSource(blob []byte, limits Limits) error {
+	if err := checkBytes(blob, limits); err != nil || limits.MaxPixels == 0 {
+		return err
+	}
+
+	mw := imagick.NewMagickWand()
+	defer mw.Destroy()
+
+	if err := mw.PingImageBlob(blob); err != nil {
+		return readError(err)
+	}
+
+	return checkPixels(mw, limits)
+}
+
+// checkBytes checks the size of blob against the limits.
+func checkBytes(blob []byte, limits Limits) error {
+	if limits.MaxBytes > 0 && int64(len(blob)) > limits.MaxBytes {
+		return fmt.Errorf("%w: %d bytes exceeds %d bytes", ErrTooLarge, len(blob), limits.MaxBytes)
+	}
+
+	return nil
+}
+
+// checkPixels checks the pinged or decoded image of mw against the pixel
+// limit.
+func checkPixels(mw *imagick.MagickWand, limits Limits) error {
+	if limits.MaxPixels == 0 {
+		return nil
+	}
+
+	pixels := uint64(mw.GetImageWidth()) * uint64(mw.GetImageHeight()) * uint64(mw.GetNumberImages())
+
+	if pixels > limits.MaxPixels {
+		return fmt.Errorf("%w: %d pixels exceeds %d pixels", ErrTooLarge, pixels, limits.MaxPixels)
+	}
+
+	return nil
+}
+
+// checkOutput checks an output size of width x height against the output
+// limits of the image.
+func (im *Image) checkOutput(width, height uint) error {
+	limits := im.limits
+
+	if limits.MaxWidth > 0 && width > limits.MaxWidth || limits.MaxHeight > 0 && height > limits.MaxHeight {
+		return fmt.Errorf("%w: %dx%d exceeds %dx%d", ErrOutputTooLarge, width, height, limits.MaxWidth, limits.MaxHeight)
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package image
+
+import (
+	"math"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// AutoOrient rotates and flips the image as described by its EXIF
+// orientation and resets the orientation to top-left, so the output is
+// displayed the same way with or without EXIF support.
+func (im *Image) AutoOrient() (err error) {
+	switch im.mw.GetImageOrientation() {
+	case imagick.ORIENTATION_UNDEFINED, imagick.ORIENTATION_TOP_LEFT:
+		return nil
+	case imagick.ORIENTATION_TOP_RIGHT:
+		err = im.mw.FlopImage()
+	case imagick.ORIENTATION_BOTTOM_RIGHT:
+		err = im.rotate(180)
+	case imagick.ORIENTATION_BOTTOM_LEFT:
+		err = im.mw.FlipImage()
+	case imagick.ORIENTATION_LEFT_TOP:
+		err = im.mw.TransposeImage()
+	case imagick.ORIENTATION_RIGHT_TOP:
+		err = im.rotate(90)
+	case imagick.ORIENTATION_RIGHT_BOTTOM:
+		err = im.mw.TransverseImage()
+	case imagick.ORIENTATION_LEFT_BOTTOM:
+		err = im.rotate(270)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if err = im.mw.SetImageOrientation(imagick.ORIENTATION_TOP_LEFT); err != nil {
+		return
+	}
+
+	if err = im.mw.SetImageProperty("exif:Orientation", "1"); err != nil {
+		return
+	}
+
+	im.w = im.mw.GetImageWidth()
+	im.h = im.mw.GetImageHeight()
+	return
+}
+
+// rotate rotates the image clockwise by a multiple of 90 degrees, which
+// leaves no background to fill.
+func (im *Image) rotate(degrees float64) error {
+	bg := imagick.NewPixelWand()
+	defer bg.Destroy()
+	bg.SetColor("none")
+	return im.mw.RotateImage(bg, degrees)
+}
+
+// Rotate rotates the image clockwise by degrees. Multiples of 90 degrees
+// are lossless. Other angles enlarge the image to hold the rotated image
+// and fill the corners with the background color.
+func (im *Image) Rotate(degrees float64) error {
+	degrees = math.Mod(degrees, 360)
+
+	if degrees < 0 {
+		degrees += 360
+	}
+
+	if degrees == 0 {
+		return nil
+	}
+
+	if math.Mod(degrees, 90) == 0 {
+		w, h := im.w, im.h
+
+		if degrees != 180 {
+			w, h = h, w
+		}
+
+		if err := im.checkOutput(w, h); err != nil {
+			return err
+		}
+
+		if err := im.rotate(degrees); err != nil {
+			return err
+		}
+
+		im.w, im.h = w, h
+		return nil
+	}
+
+	rad := degrees * math.Pi / 180
+	sin, cos := math.Abs(math.Sin(rad)), math.Abs(math.Cos(rad))
+	w := round(float64(im.w)*cos + float64(im.h)*sin)
+	h := round(float64(im.w)*sin + float64(im.h)*cos)
+
+	if err := im.checkOutput(w, h); err != nil {
+		return err
+	}
+
+	bg, err := im.fillWand()
+
+	if err != nil {
+		return err
+	}
+
+	defer bg.Destroy()
+
+	if err = im.mw.RotateImage(bg, degrees); err != nil {
+		return err
+	}
+
+	// RotateImage offsets the page, which would shift later crops.
+	if err = im.mw.ResetImagePage("0x0"); err != nil {
+		return err
+	}
+
+	im.w = im.mw.GetImageWidth()
+	im.h = im.mw.GetImageHeight()
+	return nil
+}
+
+// Flip mirrors the image vertically, top to bottom.
+func (im *Image) Flip() error {
+	return im.mw.FlipImage()
+}
+
+// Flop mirrors the image horizontally, left to right.
+func (im *Image) Flop() error {
+	return im.mw.FlopImage()
+}
@@ -0,0 +1,71 @@
+package image
+
+import (
+	"github.com/gographics/imagick/imagick"
+)
+
+// padSize returns the size of the image scaled to fit the geometry g and
+// the size of the padded canvas.
+func (im *Image) padSize(g *Geometry) (w, h, cw, ch uint) {
+	fit := *g
+	fit.Flags &^= GeometryFill | GeometryExact
+	w, h = fit.Size(im.w, im.h)
+	cw, ch = w, h
+
+	if g.Flags&GeometryPercent == 0 {
+		cw, ch = maxUint(cw, g.Width), maxUint(ch, g.Height)
+	}
+
+	return
+}
+
+// PadSize returns the output size of Pad.
+func (im *Image) PadSize(g *Geometry) (uint, uint) {
+	_, _, cw, ch := im.padSize(g)
+	return cw, ch
+}
+
+// Pad scales the image to fit the geometry g and extends the canvas to the
+// geometry size. The image is placed on the canvas by compass direction,
+// centered by default, and the added area is filled with the background
+// color.
+func (im *Image) Pad(g *Geometry) error {
+	w, h, cw, ch := im.padSize(g)
+
+	if err := im.checkOutput(cw, ch); err != nil {
+		return err
+	}
+
+	if w != im.w || h != im.h {
+		if err := im.mw.ResizeImage(w, h, imagick.FILTER_LANCZOS, 1); err != nil {
+			return err
+		}
+
+		im.w, im.h = w, h
+	}
+
+	if cw == w && ch == h {
+		return nil
+	}
+
+	bg, err := im.fillWand()
+
+	if err != nil {
+		return err
+	}
+
+	defer bg.Destroy()
+
+	if err = im.mw.SetImageBackgroundColor(bg); err != nil {
+		return err
+	}
+
+	x, y := compassOffset(im.direction, cw, ch, w, h)
+
+	if err = im.mw.ExtentImage(cw, ch, -x, -y); err != nil {
+		return err
+	}
+
+	im.w, im.h = cw, ch
+	return nil
+}
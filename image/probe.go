@@ -0,0 +1,43 @@
+package image
+
+import (
+	"errors"
+	"fmt"
+)
+
+// probePNG is a 1x1 white PNG image.
+var probePNG = []byte{
+	0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a, 0x00, 0x00, 0x00, 0x0d,
+	0x49, 0x48, 0x44, 0x52, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+	0x08, 0x02, 0x00, 0x00, 0x00, 0x90, 0x77, 0x53, 0xde, 0x00, 0x00, 0x00,
+	0x0c, 0x49, 0x44, 0x41, 0x54, 0x78, 0x9c, 0x63, 0xf8, 0xff, 0xff, 0x3f,
+	0x00, 0x05, 0xfe, 0x02, 0xfe, 0x0d, 0xef, 0x46, 0xb8, 0x00, 0x00, 0x00,
+	0x00, 0x49, 0x45, 0x4e, 0x44, 0xae, 0x42, 0x60, 0x82,
+}
+
+// Probe checks that ImageMagick is able to decode and encode images by
+// converting a tiny built-in PNG image.
+func Probe() error {
+	im, err := NewImageFromBlob(probePNG)
+	defer im.Destroy()
+
+	if err != nil {
+		return err
+	}
+
+	if im.Width() != 1 || im.Height() != 1 {
+		return fmt.Errorf("probe: decoded %dx%d image, expected 1x1", im.Width(), im.Height())
+	}
+
+	blob, err := im.Encode(&EncodeOptions{Format: FormatPNG})
+
+	if err != nil {
+		return err
+	}
+
+	if len(blob) == 0 {
+		return errors.New("probe: empty encoded image")
+	}
+
+	return nil
+}
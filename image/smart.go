@@ -0,0 +1,202 @@
+package image
+
+import (
+	"math"
+
+	"github.com/gographics/imagick/imagick"
+)
+
+// smartSize is the maximum width and height of the downscaled copy of an
+// image analysed by the smart gravities.
+const smartSize = 128
+
+// smartSteps is the maximum number of window positions tried per axis by
+// the entropy gravity.
+const smartSteps = 32
+
+// pixelMap is an RGB image with 8 bits per channel.
+type pixelMap struct {
+	w, h int
+	rgb  []byte
+}
+
+func (p *pixelMap) at(x, y int) (r, g, b float64) {
+	i := 3 * (y*p.w + x)
+	return float64(p.rgb[i]), float64(p.rgb[i+1]), float64(p.rgb[i+2])
+}
+
+func (p *pixelMap) luma(x, y int) float64 {
+	r, g, b := p.at(x, y)
+	return 0.299*r + 0.587*g + 0.114*b
+}
+
+// smartGravity returns the offset of the w x h window of the image chosen
+// by the entropy or attention analysis. The image is analysed at a reduced
+// size. If the analysis fails the window is centered.
+func (im *Image) smartGravity(w, h uint, direction string) (x, y int) {
+	cx, cy := int(im.w-w)/2, int(im.h-h)/2
+	scale := math.Min(1, float64(smartSize)/float64(maxUint(im.w, im.h)))
+	sw := maxUint(round(float64(im.w)*scale), 1)
+	sh := maxUint(round(float64(im.h)*scale), 1)
+
+	mw := im.mw.Clone()
+	defer mw.Destroy()
+
+	if err := mw.ResizeImage(sw, sh, imagick.FILTER_BOX, 1); err != nil {
+		return cx, cy
+	}
+
+	v, err := mw.ExportImagePixels(0, 0, sw, sh, "RGB", imagick.PIXEL_CHAR)
+	rgb, ok := v.([]byte)
+
+	if err != nil || !ok || len(rgb) != int(3*sw*sh) {
+		return cx, cy
+	}
+
+	p := &pixelMap{int(sw), int(sh), rgb}
+	ww := int(minUint(round(float64(w)*scale), sw))
+	wh := int(minUint(round(float64(h)*scale), sh))
+
+	var px, py int
+
+	if direction == "entropy" {
+		px, py = entropyWindow(p, ww, wh)
+	} else {
+		px, py = attentionWindow(p, ww, wh)
+	}
+
+	x = int(math.Floor(float64(px)/scale + 0.5))
+	y = int(math.Floor(float64(py)/scale + 0.5))
+	return minInt(x, int(im.w-w)), minInt(y, int(im.h-h))
+}
+
+// windowPicker keeps the best scored window, preferring the window closest
+// to the center on ties.
+type windowPicker struct {
+	cx, cy float64
+	x, y   int
+	score  float64
+	dist   float64
+	set    bool
+}
+
+func newWindowPicker(p *pixelMap, ww, wh int) *windowPicker {
+	return &windowPicker{cx: float64(p.w-ww) / 2, cy: float64(p.h-wh) / 2}
+}
+
+func (wp *windowPicker) add(x, y int, score float64) {
+	dist := math.Hypot(float64(x)-wp.cx, float64(y)-wp.cy)
+
+	if !wp.set || score > wp.score+1e-9 || score > wp.score-1e-9 && dist < wp.dist {
+		wp.x, wp.y, wp.score, wp.dist, wp.set = x, y, score, dist, true
+	}
+}
+
+// entropyWindow returns the position of the ww x wh window with the
+// highest luminance entropy.
+func entropyWindow(p *pixelMap, ww, wh int) (int, int) {
+	wp := newWindowPicker(p, ww, wh)
+	xs, ys := windowPositions(p.w-ww), windowPositions(p.h-wh)
+	var hist [32]int
+
+	for _, y := range ys {
+		for _, x := range xs {
+			hist = [32]int{}
+
+			for j := y; j < y+wh; j++ {
+				for i := x; i < x+ww; i++ {
+					hist[int(p.luma(i, j))>>3]++
+				}
+			}
+
+			n := float64(ww * wh)
+			var e float64
+
+			for _, c := range hist {
+				if c > 0 {
+					q := float64(c) / n
+					e -= q * math.Log2(q)
+				}
+			}
+
+			wp.add(x, y, e)
+		}
+	}
+
+	return wp.x, wp.y
+}
+
+// windowPositions returns at most smartSteps positions from 0 to max,
+// including both ends.
+func windowPositions(max int) []int {
+	if max <= 0 {
+		return []int{0}
+	}
+
+	steps := minInt(max, smartSteps)
+	pos := make([]int, steps+1)
+
+	for i := range pos {
+		pos[i] = i * max / steps
+	}
+
+	return pos
+}
+
+// attentionWindow returns the position of the ww x wh window with the
+// highest attention score. Pixels score by their luminance edges,
+// saturation and skin tone, approximating what draws the eye.
+func attentionWindow(p *pixelMap, ww, wh int) (int, int) {
+	// sum is a summed-area table of the pixel scores.
+	sum := make([]float64, (p.w+1)*(p.h+1))
+	stride := p.w + 1
+
+	for y := 0; y < p.h; y++ {
+		for x := 0; x < p.w; x++ {
+			sum[(y+1)*stride+x+1] = attention(p, x, y) + sum[y*stride+x+1] + sum[(y+1)*stride+x] - sum[y*stride+x]
+		}
+	}
+
+	wp := newWindowPicker(p, ww, wh)
+
+	for y := 0; y+wh <= p.h; y++ {
+		for x := 0; x+ww <= p.w; x++ {
+			score := sum[(y+wh)*stride+x+ww] - sum[y*stride+x+ww] - sum[(y+wh)*stride+x] + sum[y*stride+x]
+			wp.add(x, y, score)
+		}
+	}
+
+	return wp.x, wp.y
+}
+
+// attention scores the pixel at x, y.
+func attention(p *pixelMap, x, y int) float64 {
+	l := func(x, y int) float64 {
+		return p.luma(minInt(maxInt(x, 0), p.w-1), minInt(maxInt(y, 0), p.h-1))
+	}
+
+	edge := math.Abs(l(x+1, y)-l(x-1, y)) + math.Abs(l(x, y+1)-l(x, y-1))
+	r, g, b := p.at(x, y)
+	saturation := math.Max(r, math.Max(g, b)) - math.Min(r, math.Min(g, b))
+	score := edge + saturation/2
+
+	if r > 95 && g > 40 && b > 20 && r > g && r > b && r-g > 15 {
+		score += 64
+	}
+
+	return score
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"os"
+	"sync"
+)
+
+// File is an append only log file which can be reopened, e.g. once it was
+// moved by logrotate.
+type File struct {
+	mu   sync.Mutex
+	name string
+	f    *os.File
+}
+
+// OpenFile opens the named log file, creating it if needed.
+func OpenFile(name string) (*File, error) {
+	f := &File{name: name}
+
+	if err := f.Reopen(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Reopen closes the file and opens it again by name.
+func (f *File) Reopen() error {
+	nf, err := os.OpenFile(f.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	old := f.f
+	f.f = nf
+	f.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+
+	return nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Write(p)
+}
+
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2013 Simon Zimmermann
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package logging implements a leveled logger writing text or JSON lines.
+//
+// Messages are written with optional key value fields. Error messages are
+// also passed to a Sink, such as a Sentry compatible error tracker.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log message.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "error"}
+
+// ParseLevel parses a level name: debug, info or error.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if s == name {
+			return Level(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+func (l Level) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return strconv.Itoa(int(l))
+	}
+
+	return levelNames[l]
+}
+
+// Fields are the key value pairs of a log message.
+type Fields map[string]interface{}
+
+// Sink receives error messages, e.g. to report them to an error tracker.
+type Sink interface {
+	Capture(msg string, fields Fields)
+}
+
+// Logger writes messages of at least its level to a writer. It is safe
+// for concurrent use.
+type Logger struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+	sink  Sink
+}
+
+// New returns a Logger writing messages of at least level to w. Messages
+// are written as JSON objects if json is set and as text lines otherwise.
+func New(w io.Writer, level Level, json bool) *Logger {
+	return &Logger{w: w, level: level, json: json}
+}
+
+// SetSink sets the sink receiving error messages.
+func (l *Logger) SetSink(s Sink) {
+	l.mu.Lock()
+	l.sink = s
+	l.mu.Unlock()
+}
+
+// Log writes msg with fields at level.
+func (l *Logger) Log(level Level, msg string, fields Fields) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if level >= LevelError && l.sink != nil {
+		l.sink.Capture(msg, fields)
+	}
+
+	if level < l.level {
+		return
+	}
+
+	var line []byte
+	t := time.Now().UTC()
+
+	if l.json {
+		line = jsonLine(t, level, msg, fields)
+	} else {
+		line = textLine(t, level, msg, fields)
+	}
+
+	l.w.Write(line)
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	l.Log(LevelDebug, fmt.Sprintf(format, v...), nil)
+}
+
+func (l *Logger) Printf(format string, v ...interface{}) {
+	l.Log(LevelInfo, fmt.Sprintf(format, v...), nil)
+}
+
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	l.Log(LevelError, fmt.Sprintf(format, v...), nil)
+}
+
+// textLine formats a message as time, level and message followed by the
+// fields as key=value pairs sorted by key.
+func textLine(t time.Time, level Level, msg string, fields Fields) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %s", t.Format(time.RFC3339Nano), level, msg)
+
+	for _, k := range sortedKeys(fields) {
+		v := fmt.Sprint(fields[k])
+
+		if v == "" || bytes.ContainsAny([]byte(v), " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+// jsonLine formats a message as a JSON object holding the time, level,
+// message and fields.
+func jsonLine(t time.Time, level Level, msg string, fields Fields) []byte {
+	m := make(map[string]interface{}, len(fields)+3)
+
+	for k, v := range fields {
+		m[k] = v
+	}
+
+	m["time"] = t.Format(time.RFC3339Nano)
+	m["level"] = level.String()
+	m["msg"] = msg
+
+	line, err := json.Marshal(m)
+
+	if err != nil {
+		line, _ = json.Marshal(map[string]string{"level": level.String(), "msg": msg, "error": err.Error()})
+	}
+
+	return append(line, '\n')
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+
+	for k := range fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,156 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range levelNames {
+		l, err := ParseLevel(name)
+
+		if err != nil || l.String() != name {
+			t.Fatalf("%s: got %v %v", name, l, err)
+		}
+	}
+
+	if _, err := ParseLevel("warn"); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestLoggerText(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, LevelInfo, false)
+	l.Debugf("hidden")
+	l.Log(LevelInfo, "access", Fields{"path": "/a b.png", "status": 200})
+	line := b.String()
+
+	if strings.Contains(line, "hidden") {
+		t.Fatal("expected debug message to be dropped")
+	}
+
+	if !strings.HasSuffix(line, ` info access path="/a b.png" status=200`+"\n") {
+		t.Fatalf("unexpected line %q", line)
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var b bytes.Buffer
+	l := New(&b, LevelDebug, true)
+	l.Log(LevelError, "failed", Fields{"status": 500})
+
+	var m map[string]interface{}
+
+	if err := json.Unmarshal(b.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+
+	if m["level"] != "error" || m["msg"] != "failed" || m["status"] != 500.0 || m["time"] == nil {
+		t.Fatalf("unexpected object %v", m)
+	}
+}
+
+type captureSink []string
+
+func (s *captureSink) Capture(msg string, fields Fields) {
+	*s = append(*s, msg)
+}
+
+func TestLoggerSink(t *testing.T) {
+	var sink captureSink
+	l := New(ioutil.Discard, LevelError, false)
+	l.SetSink(&sink)
+	l.Printf("info")
+	l.Errorf("error %d", 1)
+
+	if len(sink) != 1 || sink[0] != "error 1" {
+		t.Fatalf("unexpected captured messages %v", sink)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imgfilter-log")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "imgfilter.log")
+	f, err := OpenFile(name)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+	f.Write([]byte("a\n"))
+
+	// Rotate the file like logrotate does.
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+
+	f.Write([]byte("b\n"))
+
+	for file, want := range map[string]string{name + ".1": "a\n", name: "b\n"} {
+		data, err := ioutil.ReadFile(file)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(data) != want {
+			t.Fatalf("%s: expected %q got %q", file, want, data)
+		}
+	}
+}
+
+func TestRaven(t *testing.T) {
+	events := make(chan *http.Request, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		events <- r
+	}))
+	defer ts.Close()
+
+	dsn := strings.Replace(ts.URL, "://", "://public:secret@", 1) + "/sentry/42"
+	r, err := NewRaven(dsn)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Capture("failed", Fields{"path": "/a.png"})
+	r.Close()
+	req := <-events
+
+	// Events after Close, e.g. from requests outliving shutdown, are
+	// dropped.
+	r.Capture("late", nil)
+	r.Close()
+
+	if req.URL.Path != "/sentry/api/42/store/" {
+		t.Fatalf("unexpected path %s", req.URL.Path)
+	}
+
+	if auth := req.Header.Get("X-Sentry-Auth"); !strings.Contains(auth, "sentry_key=public") || !strings.Contains(auth, "sentry_secret=secret") {
+		t.Fatalf("unexpected auth %q", auth)
+	}
+
+	if _, err := NewRaven("https://example.com/"); err == nil {
+		t.Fatal("expected error for dsn without key and project")
+	}
+}
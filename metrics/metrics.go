@@ -0,0 +1,329 @@
+// Copyright (c) 2013 Simon Zimmermann
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package metrics implements counters, gauges and histograms exposed in
+// the Prometheus text format.
+//
+// Example
+//
+//	r := metrics.NewRegistry()
+//	requests := r.NewCounter("requests_total", "Requests served.", "status")
+//	requests.Inc("200")
+//	http.Handle("/metrics", r)
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefBuckets are the default histogram buckets in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// metric is a named metric with label values.
+type metric struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is the value of a metric for one set of label values.
+type series struct {
+	values  []string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+func (m *metric) get(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := m.series[key]
+
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		m.series[key] = s
+	}
+
+	return s
+}
+
+// Registry holds metrics and writes them in the Prometheus text format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []interface{ write(w *bufio.Writer) }
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) newMetric(name, help, typ string, labels []string) metric {
+	return metric{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+}
+
+func (r *Registry) register(m interface{ write(w *bufio.Writer) }) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// NewCounter registers a counter with the given label names.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{r.newMetric(name, help, "counter", labels)}
+	r.register(c)
+	return c
+}
+
+// NewGauge registers a gauge with the given label names.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	g := &Gauge{r.newMetric(name, help, "gauge", labels)}
+	r.register(g)
+	return g
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds,
+// in increasing order, and label names.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	h := &Histogram{r.newMetric(name, help, "histogram", labels), buckets}
+	r.register(h)
+	return h
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := r.metrics
+	r.mu.Unlock()
+
+	cw := &countWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	for _, m := range metrics {
+		m.write(bw)
+	}
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// Counter is a metric which only increases.
+type Counter struct {
+	metric
+}
+
+// Add adds v, which must not be negative, to the counter of the label
+// values.
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic("metrics: counter decreased")
+	}
+
+	c.mu.Lock()
+	c.get(values).value += v
+	c.mu.Unlock()
+}
+
+// Inc adds one to the counter of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+
+	for _, s := range c.sorted() {
+		c.writeSample(w, "", s.values, "", "", s.value)
+	}
+}
+
+// Gauge is a metric which can increase and decrease.
+type Gauge struct {
+	metric
+}
+
+// Set sets the gauge of the label values to v.
+func (g *Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value = v
+	g.mu.Unlock()
+}
+
+// Add adds v to the gauge of the label values.
+func (g *Gauge) Add(v float64, values ...string) {
+	g.mu.Lock()
+	g.get(values).value += v
+	g.mu.Unlock()
+}
+
+func (g *Gauge) write(w *bufio.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.writeHeader(w)
+
+	for _, s := range g.sorted() {
+		g.writeSample(w, "", s.values, "", "", s.value)
+	}
+}
+
+// Histogram counts observations in buckets.
+type Histogram struct {
+	metric
+	bounds []float64
+}
+
+// Observe adds v to the histogram of the label values.
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := h.get(values)
+
+	if s.buckets == nil {
+		s.buckets = make([]uint64, len(h.bounds))
+	}
+
+	for i, b := range h.bounds {
+		if v <= b {
+			s.buckets[i]++
+		}
+	}
+
+	s.count++
+	s.value += v
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+
+	for _, s := range h.sorted() {
+		for i, b := range h.bounds {
+			h.writeSample(w, "_bucket", s.values, "le", formatFloat(b), float64(s.buckets[i]))
+		}
+
+		h.writeSample(w, "_bucket", s.values, "le", "+Inf", float64(s.count))
+		h.writeSample(w, "_sum", s.values, "", "", s.value)
+		h.writeSample(w, "_count", s.values, "", "", float64(s.count))
+	}
+}
+
+// sorted returns the series ordered by label values. The caller must hold
+// m.mu.
+func (m *metric) sorted() []*series {
+	keys := make([]string, 0, len(m.series))
+
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	series := make([]*series, len(keys))
+
+	for i, k := range keys {
+		series[i] = m.series[k]
+	}
+
+	return series
+}
+
+func (m *metric) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escape(m.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+}
+
+// writeSample writes a sample of the metric. An extra label is added if
+// extraName is non-empty.
+func (m *metric) writeSample(w *bufio.Writer, suffix string, values []string, extraName, extraValue string, v float64) {
+	w.WriteString(m.name + suffix)
+	var pairs []string
+
+	for i, l := range m.labels {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l, escape(values[i], true)))
+	}
+
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extraName, extraValue))
+	}
+
+	if len(pairs) > 0 {
+		w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+
+	w.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes help texts and, if quoted is set, label values.
+func escape(s string, quoted bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+
+	if quoted {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+
+	return s
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.n += int64(n)
+	return n, err
+}
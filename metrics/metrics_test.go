@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	c := r.NewCounter("requests_total", "Requests served.", "route", "status")
+	g := r.NewGauge("in_flight", "Operations in flight.")
+	h := r.NewHistogram("duration_seconds", "Request duration.", []float64{0.1, 1}, "route")
+
+	c.Inc("resize", "200")
+	c.Add(2, "resize", "200")
+	c.Inc("crop", `4"04`)
+	g.Add(2)
+	g.Add(-1)
+	h.Observe(0.05, "resize")
+	h.Observe(0.5, "resize")
+	h.Observe(5, "resize")
+
+	var b bytes.Buffer
+
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="crop",status="4\"04"} 1
+requests_total{route="resize",status="200"} 3
+# HELP in_flight Operations in flight.
+# TYPE in_flight gauge
+in_flight 1
+# HELP duration_seconds Request duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="resize",le="0.1"} 1
+duration_seconds_bucket{route="resize",le="1"} 2
+duration_seconds_bucket{route="resize",le="+Inf"} 3
+duration_seconds_sum{route="resize"} 5.55
+duration_seconds_count{route="resize"} 3
+`
+
+	if b.String() != want {
+		t.Fatalf("unexpected output\n%s", b.String())
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain; version=0.0.4") || w.Body.String() != want {
+		t.Fatalf("unexpected response %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestLabelValues(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic for missing label value")
+		}
+	}()
+
+	NewRegistry().NewCounter("requests_total", "Requests served.", "route").Inc()
+}
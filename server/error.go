@@ -0,0 +1,99 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/image"
+	"github.com/simonz05/imgfilter/sign"
+)
+
+// requestError is an error caused by an invalid request.
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// errorKinds maps errors to the HTTP status code and the stable error code
+// of their response. The first match wins.
+var errorKinds = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{sign.ErrMissingSignature, http.StatusForbidden, "missing_signature"},
+	{sign.ErrInvalidSignature, http.StatusForbidden, "invalid_signature"},
+	{sign.ErrExpired, http.StatusForbidden, "expired_signature"},
+	{backend.ErrNotFound, http.StatusNotFound, "not_found"},
+	{backend.ErrForbidden, http.StatusForbidden, "forbidden"},
+	{backend.ErrTooLarge, http.StatusRequestEntityTooLarge, "source_too_large"},
+	{backend.ErrUnavailable, http.StatusBadGateway, "backend_unavailable"},
+	{image.ErrTooLarge, http.StatusRequestEntityTooLarge, "source_too_large"},
+	{image.ErrOutputTooLarge, http.StatusUnprocessableEntity, "output_too_large"},
+	{image.ErrUnsupportedFormat, http.StatusUnsupportedMediaType, "unsupported_format"},
+	{errBusy, http.StatusServiceUnavailable, "busy"},
+}
+
+// errorStatus returns the HTTP status code and error code of err. Errors
+// of unknown kind are internal errors.
+func errorStatus(err error) (int, string) {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.status, k.code
+		}
+	}
+
+	var re *requestError
+
+	if errors.As(err, &re) {
+		return http.StatusBadRequest, "bad_request"
+	}
+
+	return http.StatusInternalServerError, "internal"
+}
+
+// errorBody is the JSON error response.
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError writes the response of err. The messages of internal errors
+// are only logged.
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	status, code := errorStatus(err)
+	msg := err.Error()
+
+	if aw, ok := w.(*accessWriter); ok {
+		aw.entry.Error = err.Error()
+	}
+
+	if status >= 500 {
+		s.logger.Errorf("err: %v", err)
+		msg = http.StatusText(status)
+	}
+
+	if err == errBusy {
+		w.Header().Set("Retry-After", retryAfter)
+	}
+
+	if s.jsonErrors {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(&errorBody{code, msg})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write([]byte(msg))
+}
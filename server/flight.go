@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// flight is an in-progress or completed call of a flightGroup.
+type flight struct {
+	done    chan struct{}
+	data    []byte
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// flightGroup coalesces concurrent calls with the same key, so that only
+// one of them does the work and all of them receive the result.
+type flightGroup struct {
+	mu sync.Mutex
+	m  map[string]*flight
+	// wg tracks running calls, including calls whose callers are gone.
+	wg sync.WaitGroup
+}
+
+// do calls fn once for all concurrent callers of key and returns its
+// result. shared reports whether the result was given to multiple callers.
+// A caller returns the context's error once its ctx is done. The context
+// passed to fn is canceled when all callers are gone.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) ([]byte, error)) (data []byte, err error, shared bool) {
+	g.mu.Lock()
+
+	if g.m == nil {
+		g.m = make(map[string]*flight)
+	}
+
+	f, ok := g.m[key]
+
+	if !ok {
+		fctx, cancel := context.WithCancel(context.Background())
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.m[key] = f
+		g.wg.Add(1)
+		go g.call(fctx, f, key, fn)
+	}
+
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		g.mu.Lock()
+		shared = f.waiters > 1
+		g.mu.Unlock()
+		return f.data, f.err, shared
+	case <-ctx.Done():
+		g.mu.Lock()
+		f.waiters--
+
+		if f.waiters == 0 {
+			f.cancel()
+			g.forget(key, f)
+		}
+
+		g.mu.Unlock()
+		return nil, ctx.Err(), false
+	}
+}
+
+func (g *flightGroup) call(ctx context.Context, f *flight, key string, fn func(context.Context) ([]byte, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			f.err = fmt.Errorf("panic: %v", r)
+		}
+
+		g.mu.Lock()
+		g.forget(key, f)
+		g.mu.Unlock()
+
+		f.cancel()
+		close(f.done)
+		g.wg.Done()
+	}()
+
+	f.data, f.err = fn(ctx)
+}
+
+// wait waits for all running calls to return.
+func (g *flightGroup) wait() {
+	g.wg.Wait()
+}
+
+// forget removes f from the group unless it was already replaced by a new
+// call. The caller must hold g.mu.
+func (g *flightGroup) forget(key string, f *flight) {
+	if g.m[key] == f {
+		delete(g.m, key)
+	}
+}
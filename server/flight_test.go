@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroup(t *testing.T) {
+	var (
+		g     flightGroup
+		calls int32
+		wg    sync.WaitGroup
+	)
+
+	start := make(chan struct{})
+	fn := func(ctx context.Context) ([]byte, error) {
+		atomic.AddInt32(&calls, 1)
+		<-start
+		return []byte("foo"), nil
+	}
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+			data, err, _ := g.do(context.Background(), "key", fn)
+
+			if err != nil || string(data) != "foo" {
+				t.Errorf("expected foo got %q %v", data, err)
+			}
+		}()
+	}
+
+	// Wait for all callers to join the flight.
+	for {
+		g.mu.Lock()
+		f := g.m["key"]
+		n := 0
+
+		if f != nil {
+			n = f.waiters
+		}
+
+		g.mu.Unlock()
+
+		if n == 10 {
+			break
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	close(start)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("expected 1 call got %d", calls)
+	}
+}
+
+func TestFlightGroupError(t *testing.T) {
+	var g flightGroup
+	errFoo := errors.New("foo")
+
+	_, err, _ := g.do(context.Background(), "key", func(ctx context.Context) ([]byte, error) {
+		return nil, errFoo
+	})
+
+	if err != errFoo {
+		t.Fatalf("expected %v got %v", errFoo, err)
+	}
+
+	_, err, _ = g.do(context.Background(), "key", func(ctx context.Context) ([]byte, error) {
+		panic("bar")
+	})
+
+	if err == nil || err.Error() != "panic: bar" {
+		t.Fatalf("expected panic error got %v", err)
+	}
+}
+
+func TestFlightGroupCancel(t *testing.T) {
+	var g flightGroup
+	canceled := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err, _ := g.do(ctx, "key", func(fctx context.Context) ([]byte, error) {
+		<-fctx.Done()
+		close(canceled)
+		return nil, fctx.Err()
+	})
+
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("expected flight to be canceled")
+	}
+}
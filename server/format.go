@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/simonz05/imgfilter/image"
+)
+
+// outputFormat returns the output format requested by the fm parameter or,
+// failing that, negotiated from the Accept header. An empty format keeps
+// the source format. negotiated reports whether the result depends on the
+// Accept header.
+func (s *Server) outputFormat(query url.Values, accept string) (f image.Format, negotiated bool, err error) {
+	if v := query.Get("fm"); v != "" {
+		if f, err = image.ParseFormat(v); err != nil {
+			return
+		}
+
+		if !image.Supported(f) {
+			err = fmt.Errorf("%w %q", image.ErrUnsupportedFormat, v)
+		}
+
+		return
+	}
+
+	if len(s.formats) == 0 {
+		return
+	}
+
+	for _, af := range s.formats {
+		if accepts(accept, af.MimeType()) {
+			return af, true, nil
+		}
+	}
+
+	return "", true, nil
+}
+
+// accepts reports whether the Accept header explicitly lists mimeType with
+// a non-zero quality. Wildcards are ignored as clients send image/* without
+// supporting every image format.
+func accepts(accept, mimeType string) bool {
+	for _, v := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(v)
+
+		if err != nil || mt != mimeType {
+			continue
+		}
+
+		if q, ok := params["q"]; ok {
+			if qv, err := strconv.ParseFloat(q, 64); err != nil || qv <= 0 {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// encodeParams are the parameters read by outputFormat and
+// parseEncodeOptions.
+var encodeParams = map[string]bool{
+	"fm":          true,
+	"q":           true,
+	"progressive": true,
+	"chroma":      true,
+	"compression": true,
+	"strip":       true,
+	"bg":          true,
+}
+
+// parseEncodeOptions reads the encoder settings from the parameters q,
+// progressive, chroma, compression, strip and bg into opt. Quality falls back
+// to the server default and is capped at the server maximum.
+func (s *Server) parseEncodeOptions(query url.Values, opt *image.EncodeOptions) (err error) {
+	opt.Quality = s.quality
+
+	if v := query.Get("q"); v != "" {
+		q, err := strconv.ParseUint(v, 10, 8)
+
+		if err != nil || q < 1 || q > 100 {
+			return fmt.Errorf("invalid quality %q", v)
+		}
+
+		opt.Quality = uint(q)
+	}
+
+	if s.maxQuality > 0 && opt.Quality > s.maxQuality {
+		opt.Quality = s.maxQuality
+	}
+
+	if v := query.Get("progressive"); v != "" {
+		if opt.Progressive, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid progressive %q", v)
+		}
+	}
+
+	if v := query.Get("chroma"); v != "" {
+		if opt.Subsampling, err = image.ParseSubsampling(v); err != nil {
+			return
+		}
+	}
+
+	if v := query.Get("compression"); v != "" {
+		c, err := strconv.ParseUint(v, 10, 8)
+
+		if err != nil || c < 1 || c > 9 {
+			return fmt.Errorf("invalid compression %q", v)
+		}
+
+		opt.Compression = uint(c)
+	}
+
+	if v := query.Get("strip"); v != "" {
+		if opt.Strip, err = strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("invalid strip %q", v)
+		}
+	}
+
+	if v := query.Get("bg"); v != "" {
+		if opt.Background, err = image.ParseColor(v); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
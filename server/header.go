@@ -0,0 +1,88 @@
+package server
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CacheControl configures the Cache-Control header of image responses.
+type CacheControl struct {
+	// MaxAge is the time clients may cache an image. The Cache-Control
+	// header is omitted if MaxAge is zero.
+	MaxAge time.Duration
+	// Immutable marks images as never changing during MaxAge.
+	Immutable bool
+}
+
+func (c CacheControl) String() string {
+	if c.MaxAge <= 0 {
+		return ""
+	}
+
+	s := fmt.Sprintf("public, max-age=%d", int64(c.MaxAge/time.Second))
+
+	if c.Immutable {
+		s += ", immutable"
+	}
+
+	return s
+}
+
+// etag returns a strong entity tag for a key identifying both the source
+// version and the transformation.
+func etag(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func (s *Server) setCacheHeaders(w http.ResponseWriter, tag string, modTime time.Time) {
+	h := w.Header()
+	h.Set("Etag", tag)
+
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+
+	if cc := s.cacheControl.String(); cc != "" {
+		h.Set("Cache-Control", cc)
+	}
+}
+
+// checkNotModified reports whether the client copy identified by the
+// If-None-Match or If-Modified-Since request headers is still valid.
+// If-None-Match takes precedence as described in RFC 7232.
+func checkNotModified(r *http.Request, tag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatch(inm, tag)
+	}
+
+	if modTime.IsZero() {
+		return false
+	}
+
+	t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+
+	if err != nil {
+		return false
+	}
+
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// etagMatch reports whether tag is in the If-None-Match list using the weak
+// comparison function.
+func etagMatch(list, tag string) bool {
+	for _, v := range strings.Split(list, ",") {
+		v = strings.TrimSpace(v)
+
+		if v == "*" || strings.TrimPrefix(v, "W/") == tag {
+			return true
+		}
+	}
+
+	return false
+}
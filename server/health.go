@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/image"
+)
+
+// checkResult is the result of a readiness check.
+type checkResult struct {
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// healthBody is the response of the health endpoints.
+type healthBody struct {
+	Status string                  `json:"status"`
+	Checks map[string]*checkResult `json:"checks,omitempty"`
+}
+
+// healthz reports that the process is alive.
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, &healthBody{Status: "ok"})
+}
+
+// checkTimeout bounds each readiness check.
+var checkTimeout = 2 * time.Second
+
+// readyz reports whether the server is able to serve images. ImageMagick
+// is checked by converting a tiny image and the backend by looking up the
+// probe file, if configured.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+
+	checks := map[string]func(context.Context) error{
+		"imagemagick": s.probeImage,
+	}
+
+	if s.probeFile != "" {
+		checks["backend"] = s.probeBackend
+	}
+
+	body := &healthBody{Status: "ok", Checks: make(map[string]*checkResult)}
+	code := http.StatusOK
+
+	for name, check := range checks {
+		ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+		start := time.Now()
+		err := withContext(ctx, check)
+		cancel()
+		res := &checkResult{
+			Status:    "ok",
+			LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		}
+
+		if err != nil {
+			s.logger.Errorf("readyz %s: %v", name, err)
+			res.Status = "error"
+			res.Error = err.Error()
+			body.Status = "error"
+			code = http.StatusServiceUnavailable
+		}
+
+		body.Checks[name] = res
+	}
+
+	writeJSON(w, code, body)
+}
+
+// withContext returns the result of check, or the context's error if ctx
+// is done first. The check keeps running in the background then.
+func withContext(ctx context.Context, check func(context.Context) error) error {
+	done := make(chan error, 1)
+
+	go func() {
+		done <- check(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// probeImage converts a tiny image. It does not wait for an image operation
+// slot, so a server busy with requests stays ready.
+func (s *Server) probeImage(ctx context.Context) error {
+	return image.Probe()
+}
+
+// probeBackend looks up the probe file, reading it only if the backend is
+// unable to stat files. Backends take no context, so the lookup is bounded
+// by readyz.
+func (s *Server) probeBackend(ctx context.Context) error {
+	if sb, ok := s.backend.(backend.StatBackend); ok {
+		_, err := sb.Stat(s.probeFile)
+		return err
+	}
+
+	_, err := s.backend.ReadFile(s.probeFile)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
@@ -1,227 +1,495 @@
 package server
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/simonz05/imgfilter/backend"
 	"github.com/simonz05/imgfilter/image"
-	"github.com/simonz05/util/log"
 )
 
-var (
-	resizeRe    = regexp.MustCompile("([0-9]+)x([0-9]+)(.+)")
-	cropRe      = regexp.MustCompile("([0-9]+)x([0-9]+)(\\+([-0-9]+)\\+([-0-9])+)?(/(northwest|northeast|southwest|southeast|north|west|south|east|center))?(.+)")
-	thumbnailRe = regexp.MustCompile("([0-9]+)x([0-9]+)(/(northwest|northeast|southwest|southeast|north|west|south|east|center))?(.+)")
-)
+var directionRe = regexp.MustCompile("^(northwest|northeast|southwest|southeast|north|west|south|east|center|smart|entropy|attention)$")
 
-type FileInfo struct {
-	width, height uint
-	x, y          int
-	direction     string
-	filepath      string
-}
+// parseDirection returns the canonical form of the gravity direction v,
+// either a named direction or a focal point such as fp:0.3,0.7. ok is false
+// if v is not a direction. A malformed focal point is an error.
+func parseDirection(v string) (direction string, ok bool, err error) {
+	if strings.HasPrefix(v, "fp:") {
+		fp, err := image.ParseFocalPoint(v)
 
-func (f *FileInfo) String() string {
-	return fmt.Sprintf("%dx%d+%d+%d:%s\n%s", f.width, f.height, f.x, f.y, f.direction, f.filepath)
-}
+		if err != nil {
+			return "", false, err
+		}
 
-func validContentType(mime string) error {
-	if mime == "image/png" || mime == "image/jpeg" {
-		return nil
+		return fp.String(), true, nil
 	}
-	return errors.New("Invalid MIME type")
+
+	return v, directionRe.MatchString(v), nil
 }
 
-func writeError(w http.ResponseWriter, err string, statusCode int) {
-	log.Printf("err: %v", err)
-	w.WriteHeader(statusCode)
-	w.Write([]byte(err))
+type FileInfo struct {
+	geometry  *image.Geometry
+	direction string
+	ops       []pipelineOp
+	params    url.Values
+	filepath  string
+	options   image.EncodeOptions
+	stats     filterStats
 }
 
-type ImageFilter interface {
-	SizeParser(string) (*FileInfo, error)
-	Filter([]byte, *FileInfo) ([]byte, error)
+func (f *FileInfo) String() string {
+	return fmt.Sprintf("%s:%s:%s", f.transform(), &f.options, f.filepath)
 }
 
-type ThumbnailFilter struct {
-	re *regexp.Regexp
+// Key returns a normalized cache key for the file info.
+func (f *FileInfo) Key() string {
+	return fmt.Sprintf("%s/%s/%s", f.transform(), &f.options, f.filepath)
 }
 
-func NewThumbnailFilter() *ThumbnailFilter {
-	return &ThumbnailFilter{re: thumbnailRe}
+// transform returns a canonical representation of the transformation.
+func (f *FileInfo) transform() string {
+	if f.ops != nil {
+		return pipelineString(f.ops)
+	}
+
+	return fmt.Sprintf("%s/%s", f.geometry, f.direction)
 }
 
-// parseThumbnail validates the file info for a thumbnail.
-func (t *ThumbnailFilter) SizeParser(v string) (f *FileInfo, err error) {
-	result := t.re.FindStringSubmatch(v)
+// parseFileInfo parses v of the form geometry[/direction]/filepath. The
+// direction segment is only recognized if withDirection is set.
+func parseFileInfo(v string, withDirection bool) (f *FileInfo, err error) {
+	i := strings.IndexByte(v, '/')
 
-	if len(result) != 6 {
+	if i < 0 {
 		err = errors.New("string mismatch")
 		return
 	}
 
-	width, err := strconv.ParseUint(result[1], 10, 16)
+	geometry, err := image.ParseGeometry(v[:i])
 
 	if err != nil {
 		return
 	}
 
-	height, err := strconv.ParseUint(result[2], 10, 16)
+	f = &FileInfo{geometry: geometry}
+	v = v[i+1:]
 
-	if err != nil {
-		return
+	if i = strings.IndexByte(v, '/'); withDirection && i > 0 {
+		direction, ok, err := parseDirection(v[:i])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			f.direction = direction
+			v = v[i+1:]
+		}
 	}
 
-	filepath := path.Clean(result[5][1:]) // remove first slash
+	f.filepath = path.Clean(v)
 
-	f = &FileInfo{
-		width:     uint(width),
-		height:    uint(height),
-		direction: result[4],
-		filepath:  filepath,
+	if v == "" || f.filepath == "." || f.filepath == "/" {
+		f, err = nil, errors.New("missing file path")
 	}
+
 	return
 }
 
-func (t *ThumbnailFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
-	return image.Thumbnail(data, f.width, f.height, f.direction)
+func validContentType(mime string) error {
+	switch mime {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		return nil
+	}
+	return fmt.Errorf("%w: %s", image.ErrUnsupportedFormat, mime)
+}
+
+type ImageFilter interface {
+	SizeParser(string) (*FileInfo, error)
+	Filter([]byte, *FileInfo) ([]byte, error)
+}
+
+type ThumbnailFilter struct{}
+
+func NewThumbnailFilter() *ThumbnailFilter {
+	return &ThumbnailFilter{}
 }
 
-type CropFilter struct {
-	re *regexp.Regexp
+// SizeParser validates the file info for a thumbnail.
+func (t *ThumbnailFilter) SizeParser(v string) (*FileInfo, error) {
+	return parseFileInfo(v, true)
 }
 
+func (t *ThumbnailFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	op := func(im *image.Image) error {
+		im.SetDirection(f.direction)
+		return im.Thumbnail(f.geometry)
+	}
+
+	return image.PipelineStats(data, []image.Operation{op}, &f.options, &f.stats.Stats)
+}
+
+type CropFilter struct{}
+
 func NewCropFilter() *CropFilter {
-	return &CropFilter{re: cropRe}
+	return &CropFilter{}
 }
 
-// parseThumbnail validates the file info for a thumbnail.
-func (t *CropFilter) SizeParser(v string) (f *FileInfo, err error) {
-	result := t.re.FindStringSubmatch(v)
+// SizeParser validates the file info for a crop.
+func (t *CropFilter) SizeParser(v string) (*FileInfo, error) {
+	return parseFileInfo(v, true)
+}
 
-	if len(result) != 9 {
-		err = errors.New("string mismatch")
-		return
+func (t *CropFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	op := func(im *image.Image) error {
+		im.SetDirection(f.direction)
+		return im.Crop(f.geometry)
 	}
 
-	width, err := strconv.ParseUint(result[1], 10, 16)
+	return image.PipelineStats(data, []image.Operation{op}, &f.options, &f.stats.Stats)
+}
+
+type PadFilter struct{}
+
+func NewPadFilter() *PadFilter {
+	return &PadFilter{}
+}
+
+// SizeParser validates the file info for a pad.
+func (t *PadFilter) SizeParser(v string) (*FileInfo, error) {
+	return parseFileInfo(v, true)
+}
+
+func (t *PadFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	op := func(im *image.Image) error {
+		im.SetDirection(f.direction)
+		return im.Pad(f.geometry)
+	}
+
+	return image.PipelineStats(data, []image.Operation{op}, &f.options, &f.stats.Stats)
+}
+
+type ResizeFilter struct{}
+
+func NewResizeFilter() *ResizeFilter {
+	return &ResizeFilter{}
+}
+
+// SizeParser validates the file info for a resize.
+func (t *ResizeFilter) SizeParser(v string) (*FileInfo, error) {
+	return parseFileInfo(v, false)
+}
+
+func (t *ResizeFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	op := func(im *image.Image) error {
+		return im.Resize(f.geometry)
+	}
+
+	return image.PipelineStats(data, []image.Operation{op}, &f.options, &f.stats.Stats)
+}
+
+func (s *Server) imageHandle(w http.ResponseWriter, r *http.Request, name string, f ImageFilter) {
+	s.serveJob(w, r, name, func(w http.ResponseWriter, r *http.Request) (*job, error) {
+		fi, err := f.SizeParser(mux.Vars(r)["fileinfo"])
+
+		if err != nil {
+			return nil, &requestError{err}
+		}
+
+		// Parameters given in the path take precedence over the query.
+		query := r.URL.Query()
+
+		for k, v := range fi.params {
+			query[k] = v
+		}
+
+		format, negotiated, err := s.outputFormat(query, r.Header.Get("Accept"))
+
+		if err != nil {
+			return nil, &requestError{err}
+		}
+
+		if negotiated {
+			w.Header().Add("Vary", "Accept")
+		}
+
+		fi.options.Format = format
+		fi.options.DefaultBackground = s.background
+		fi.options.KeepOrientation = s.keepOrient
+		fi.options.Limits = s.limits
+
+		if err := s.parseEncodeOptions(query, &fi.options); err != nil {
+			return nil, &requestError{err}
+		}
+
+		return &job{
+			filepath: fi.filepath,
+			key:      name + "/" + fi.Key(),
+			stats:    &fi.stats,
+			run: func(data []byte) ([]byte, error) {
+				return f.Filter(data, fi)
+			},
+			write: func(w http.ResponseWriter, body []byte) {
+				writeImage(w, body, format.MimeType())
+			},
+		}, nil
+	})
+}
+
+// job is the work of a request on a source image.
+type job struct {
+	filepath string
+	// key identifies the response, excluding the source version.
+	key   string
+	stats *filterStats
+	// run computes the response body from the source image.
+	run   func(data []byte) ([]byte, error)
+	write func(w http.ResponseWriter, body []byte)
+}
+
+// serveJob handles the parts of a request shared by image and info
+// responses: signature verification, conditional requests, caching,
+// coalescing of identical requests, access logging and metrics under name.
+// newJob parses the request; its errors are written as is.
+func (s *Server) serveJob(w http.ResponseWriter, r *http.Request, name string, newJob func(http.ResponseWriter, *http.Request) (*job, error)) {
+	s.inflight.Add(1)
+	defer s.inflight.Done()
+
+	start := time.Now()
+	entry := &AccessEntry{Method: r.Method, Path: logPath(r.URL)}
+	w = &accessWriter{ResponseWriter: w, entry: entry}
+
+	defer func() {
+		entry.Duration = time.Since(start)
+		s.logAccess(entry)
+		s.metrics.observeRequest(name, entry)
+	}()
+
+	if s.signer != nil {
+		if err := s.signer.Verify(r.URL.Path, r.URL.Query()); err != nil {
+			s.writeError(w, err)
+			return
+		}
+	}
+
+	j, err := newJob(w, r)
 
 	if err != nil {
+		s.writeError(w, err)
 		return
 	}
 
-	height, err := strconv.ParseUint(result[2], 10, 16)
+	version, modTime, err := s.sourceVersion(j.filepath, entry)
 
 	if err != nil {
+		s.writeError(w, err)
 		return
 	}
 
-	x, _ := strconv.ParseInt(result[4], 10, 16)
-	y, _ := strconv.ParseInt(result[5], 10, 16)
-	filepath := path.Clean(result[8][1:]) // remove first slash
+	// Without a version the cache is consulted before the source is read,
+	// so changes to the source are not seen until the entry is evicted.
+	key := j.key
 
-	f = &FileInfo{
-		width:     uint(width),
-		height:    uint(height),
-		direction: result[7],
-		x:         int(x),
-		y:         int(y),
-		filepath:  filepath,
+	if version != "" {
+		key += "@" + version
 	}
-	return
-}
 
-func (t *CropFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
-	return image.Crop(data, f.width, f.height, f.x, f.y, f.direction)
-}
+	tag := etag(key)
 
-type ResizeFilter struct {
-	re *regexp.Regexp
-}
+	if checkNotModified(r, tag, modTime) {
+		s.setCacheHeaders(w, tag, modTime)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
 
-func NewResizeFilter() *ResizeFilter {
-	return &ResizeFilter{re: resizeRe}
-}
+	if s.cache != nil {
+		body, ok := s.cache.Get(key)
+		s.metrics.observeCache(ok)
+
+		if ok {
+			entry.CacheHit = true
+			entry.OutputSize = len(body)
+			s.setCacheHeaders(w, tag, modTime)
+			j.write(w, body)
+			return
+		}
+	}
 
-// parseThumbnail validates the file info for a thumbnail.
-func (t *ResizeFilter) SizeParser(v string) (f *FileInfo, err error) {
-	result := t.re.FindStringSubmatch(v)
+	// Identical concurrent requests share a single job.
+	body, err, shared := s.flights.do(r.Context(), key, func(ctx context.Context) ([]byte, error) {
+		return s.processJob(ctx, j, key)
+	})
 
-	if len(result) != 4 {
-		err = errors.New("string mismatch")
+	if r.Context().Err() != nil {
+		// The client is gone and the work may still be running.
+		entry.Status = statusClientClosed
+		entry.Error = r.Context().Err().Error()
 		return
 	}
 
-	width, err := strconv.ParseUint(result[1], 10, 16)
+	// The work is done, so its stats are safe to read.
+	entry.Shared = shared
+	entry.Fetch += j.stats.fetch
+	entry.Decode = j.stats.Decode
+	entry.Transform = j.stats.Transform
+	entry.Encode = j.stats.Encode
+
+	if j.stats.sourceSize > 0 {
+		entry.SourceSize = j.stats.sourceSize
+	}
 
 	if err != nil {
+		s.writeError(w, err)
 		return
 	}
 
-	height, err := strconv.ParseUint(result[2], 10, 16)
+	entry.OutputSize = len(body)
+	s.setCacheHeaders(w, tag, modTime)
+	j.write(w, body)
+}
+
+// sourceVersion returns the version of the source image name, which is its
+// backend ETag or modification time. The version is empty if the backend does
+// not implement StatBackend or knows neither.
+func (s *Server) sourceVersion(name string, entry *AccessEntry) (version string, modTime time.Time, err error) {
+	sb, ok := s.backend.(backend.StatBackend)
+
+	if !ok {
+		return "", time.Time{}, nil
+	}
+
+	fetchStart := time.Now()
+	stat, err := sb.Stat(name)
+	entry.Fetch = time.Since(fetchStart)
+
+	if err == nil {
+		err = s.checkSourceSize(stat.Size)
+	}
 
 	if err != nil {
-		return
+		return "", time.Time{}, err
 	}
 
-	filepath := path.Clean(result[3][1:]) // remove first slash
+	entry.SourceSize = stat.Size
+	version = stat.ETag
 
-	f = &FileInfo{
-		width:    uint(width),
-		height:   uint(height),
-		filepath: filepath,
+	if version == "" && !stat.ModTime.IsZero() {
+		version = strconv.FormatInt(stat.ModTime.UnixNano(), 36)
 	}
-	return
-}
 
-func (t *ResizeFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
-	return image.Resize(data, f.width, f.height)
+	return version, stat.ModTime, nil
 }
 
-func imageHandle(w http.ResponseWriter, r *http.Request, f ImageFilter) {
-	start := time.Now()
-	m := mux.Vars(r)
-	log.Println(m["fileinfo"])
+// readFile reads the source image. Backends implementing StatBackend are
+// streamed, so reads stop at the source size limit.
+func (s *Server) readFile(name string) ([]byte, error) {
+	sb, ok := s.backend.(backend.StatBackend)
+
+	if !ok {
+		return s.backend.ReadFile(name)
+	}
 
-	fi, err := f.SizeParser(m["fileinfo"])
+	rc, err := sb.Open(name)
 
 	if err != nil {
-		writeError(w, err.Error(), 400)
-		return
+		return nil, err
 	}
 
-	log.Println(fi)
+	defer rc.Close()
+
+	var r io.Reader = rc
+
+	if max := s.limits.MaxBytes; max > 0 {
+		r = io.LimitReader(rc, max+1)
+	}
 
-	data, err := imageBackend.ReadFile(fi.filepath)
+	data, err := ioutil.ReadAll(r)
 
 	if err != nil {
-		writeError(w, err.Error(), 400)
-		return
+		return nil, err
 	}
 
-	mimeType := http.DetectContentType(data)
+	if err = s.checkSourceSize(int64(len(data))); err != nil {
+		return nil, err
+	}
 
-	if err := validContentType(mimeType); err != nil {
-		writeError(w, err.Error(), 400)
-		return
+	return data, nil
+}
+
+// checkSourceSize checks a source size of n bytes against the limits.
+func (s *Server) checkSourceSize(n int64) error {
+	if max := s.limits.MaxBytes; max > 0 && n > max {
+		return fmt.Errorf("%w: %d bytes exceeds %d bytes", image.ErrTooLarge, n, max)
 	}
 
-	thumb, err := f.Filter(data, fi)
+	return nil
+}
+
+// processJob reads the source image, runs the job and stores the result in
+// the cache.
+func (s *Server) processJob(ctx context.Context, j *job, key string) ([]byte, error) {
+	start := time.Now()
+	data, err := s.readFile(j.filepath)
+	j.stats.fetch = time.Since(start)
 
 	if err != nil {
-		writeError(w, err.Error(), 400)
-		return
+		return nil, err
+	}
+
+	j.stats.sourceSize = int64(len(data))
+
+	if err = validContentType(http.DetectContentType(data)); err != nil {
+		return nil, err
+	}
+
+	body, err := s.runJob(ctx, j, data)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cache != nil {
+		if err := s.cache.Set(key, body); err != nil {
+			s.logger.Errorf("cache set: %v", err)
+		}
+	}
+
+	return body, nil
+}
+
+// runJob runs the job once an image operation slot is acquired.
+func (s *Server) runJob(ctx context.Context, j *job, data []byte) ([]byte, error) {
+	if err := s.limiter.acquire(ctx); err != nil {
+		return nil, err
+	}
+
+	defer s.limiter.release()
+
+	s.metrics.operations.Add(1)
+	defer s.metrics.operations.Add(-1)
+
+	return j.run(data)
+}
+
+// writeImage writes data with the given MIME type, which is detected from
+// data if empty.
+func writeImage(w http.ResponseWriter, data []byte, mimeType string) {
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
 	}
 
-	newMimeType := http.DetectContentType(thumb)
-	w.Header().Set("Content-Type", newMimeType)
-	w.Header().Set("Content-Length", strconv.Itoa(len(thumb)))
-	w.Write(thumb)
-	log.Printf("Image Handle OK %v", time.Since(start))
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Write(data)
 }
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/simonz05/imgfilter/image"
+)
+
+// infoSize is an image size in an info response.
+type infoSize struct {
+	Width  uint `json:"width"`
+	Height uint `json:"height"`
+}
+
+// infoBody is the response of the info endpoint.
+type infoBody struct {
+	Width       uint                 `json:"width"`
+	Height      uint                 `json:"height"`
+	Format      image.Format         `json:"format"`
+	Alpha       bool                 `json:"alpha"`
+	Orientation int                  `json:"orientation"`
+	ColorSpace  string               `json:"color_space"`
+	Profile     string               `json:"profile,omitempty"`
+	Frames      uint                 `json:"frames"`
+	Size        int64                `json:"size"`
+	Output      map[string]*infoSize `json:"output,omitempty"`
+}
+
+// infoOutputs are the query parameters holding a geometry for which the
+// output size is computed.
+var infoOutputs = map[string]func(*image.Image, *image.Geometry) (uint, uint){
+	"crop":      (*image.Image).CropSize,
+	"pad":       (*image.Image).PadSize,
+	"resize":    (*image.Image).ResizeSize,
+	"thumbnail": (*image.Image).ThumbnailSize,
+}
+
+// infoHandle responds with a JSON description of the source image. The
+// output size of a crop, pad, resize or thumbnail is included if the geometry
+// is given in the query parameter of that name. Responses are cached and
+// validated like image responses.
+func (s *Server) infoHandle(w http.ResponseWriter, r *http.Request) {
+	s.serveJob(w, r, "info", func(w http.ResponseWriter, r *http.Request) (*job, error) {
+		v := mux.Vars(r)["filepath"]
+		filepath := path.Clean(v)
+
+		if v == "" || filepath == "." || filepath == "/" {
+			return nil, &requestError{errors.New("missing file path")}
+		}
+
+		query := r.URL.Query()
+		geometries := make(map[string]*image.Geometry)
+
+		for name := range infoOutputs {
+			if gv := query.Get(name); gv != "" {
+				g, err := image.ParseGeometry(gv)
+
+				if err != nil {
+					return nil, &requestError{err}
+				}
+
+				geometries[name] = g
+			}
+		}
+
+		opt := &image.EncodeOptions{KeepOrientation: s.keepOrient, Limits: s.limits}
+		var st filterStats
+
+		return &job{
+			filepath: filepath,
+			key:      "info/" + infoKey(geometries, opt) + "/" + filepath,
+			stats:    &st,
+			run: func(data []byte) ([]byte, error) {
+				return describeImage(data, geometries, opt, &st)
+			},
+			write: writeInfo,
+		}, nil
+	})
+}
+
+// infoKey returns a canonical representation of the output geometries and
+// the options changing an info response.
+func infoKey(geometries map[string]*image.Geometry, opt *image.EncodeOptions) string {
+	names := make([]string, 0, len(geometries))
+
+	for name := range geometries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	parts := []string{fmt.Sprintf("keeporient=%t", opt.KeepOrientation)}
+
+	for _, name := range names {
+		parts = append(parts, name+"="+geometries[name].String())
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// describeImage returns the JSON description of the source image. Only the
+// image header is read.
+func describeImage(data []byte, geometries map[string]*image.Geometry, opt *image.EncodeOptions, st *filterStats) ([]byte, error) {
+	start := time.Now()
+	im, err := image.PingImageFromBlob(data, opt)
+	st.Decode = time.Since(start)
+	defer im.Destroy()
+
+	if err != nil {
+		return nil, err
+	}
+
+	info := im.Info()
+	body := &infoBody{
+		Width:       info.Width,
+		Height:      info.Height,
+		Format:      info.Format,
+		Alpha:       info.Alpha,
+		Orientation: info.Orientation,
+		ColorSpace:  info.ColorSpace,
+		Profile:     info.Profile,
+		Frames:      info.Frames,
+		Size:        int64(len(data)),
+	}
+
+	for name, g := range geometries {
+		if body.Output == nil {
+			body.Output = make(map[string]*infoSize)
+		}
+
+		width, height := infoOutputs[name](im, g)
+		body.Output[name] = &infoSize{width, height}
+	}
+
+	return json.Marshal(body)
+}
+
+// writeInfo writes the JSON info response body.
+func writeInfo(w http.ResponseWriter, body []byte) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Write(body)
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"errors"
+)
+
+var errBusy = errors.New("server busy")
+
+// retryAfter is the Retry-After header value, in seconds, of requests
+// rejected by the limiter.
+const retryAfter = "1"
+
+// limiter bounds the number of concurrent image operations. Up to queue
+// callers wait for a free slot, further callers are rejected.
+type limiter struct {
+	slots    chan struct{}
+	admitted chan struct{}
+}
+
+// newLimiter returns a limiter allowing concurrency operations at a time,
+// or nil if concurrency is not positive.
+func newLimiter(concurrency, queue int) *limiter {
+	if concurrency <= 0 {
+		return nil
+	}
+
+	if queue < 0 {
+		queue = 0
+	}
+
+	return &limiter{
+		slots:    make(chan struct{}, concurrency),
+		admitted: make(chan struct{}, concurrency+queue),
+	}
+}
+
+// acquire waits for a free slot. It returns errBusy if the queue is full
+// and the context's error if ctx is done while waiting. A nil limiter
+// never blocks.
+func (l *limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case l.admitted <- struct{}{}:
+	default:
+		return errBusy
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		<-l.admitted
+		return ctx.Err()
+	}
+}
+
+// release frees a slot acquired by acquire.
+func (l *limiter) release() {
+	if l == nil {
+		return
+	}
+
+	<-l.slots
+	<-l.admitted
+}
+
+// active returns the number of operations holding a slot.
+func (l *limiter) active() int {
+	if l == nil {
+		return 0
+	}
+
+	return len(l.slots)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(1, 1)
+	ctx := context.Background()
+
+	if err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+
+	go func() {
+		acquired <- l.acquire(ctx)
+	}()
+
+	// Wait for the goroutine to be queued.
+	for len(l.admitted) != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := l.acquire(ctx); err != errBusy {
+		t.Fatalf("expected errBusy got %v", err)
+	}
+
+	l.release()
+
+	if err := <-acquired; err != nil {
+		t.Fatal(err)
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	if err := l.acquire(cctx); err != context.Canceled {
+		t.Fatalf("expected context.Canceled got %v", err)
+	}
+
+	l.release()
+
+	if l.active() != 0 || len(l.admitted) != 0 {
+		t.Fatalf("expected empty limiter got %d active %d admitted", l.active(), len(l.admitted))
+	}
+
+	var unlimited *limiter
+
+	if err := unlimited.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	unlimited.release()
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/simonz05/imgfilter/image"
+	"github.com/simonz05/imgfilter/sign"
+)
+
+// logPath returns the path and query of u without the URL signature, so
+// that readers of the log are unable to replay signed URLs.
+func logPath(u *url.URL) string {
+	q := u.Query()
+
+	if _, ok := q[sign.SignatureParam]; !ok {
+		return u.RequestURI()
+	}
+
+	q.Del(sign.SignatureParam)
+	p := u.EscapedPath()
+
+	if len(q) > 0 {
+		p += "?" + q.Encode()
+	}
+
+	return p
+}
+
+// AccessEntry describes a served image request.
+type AccessEntry struct {
+	Method string
+	// Path is the request path and query without the URL signature.
+	Path   string
+	Status int
+	// Bytes is the size of the response body.
+	Bytes int64
+	// SourceSize is the size of the source image if known.
+	SourceSize int64
+	// OutputSize is the size of the filtered image or info response.
+	OutputSize int
+	// Fetch is the time spent in the backend.
+	Fetch     time.Duration
+	Decode    time.Duration
+	Transform time.Duration
+	Encode    time.Duration
+	Duration  time.Duration
+	CacheHit  bool
+	// Shared reports whether the filtered image was shared with identical
+	// concurrent requests.
+	Shared bool
+	Error  string
+}
+
+// Fields returns the entry as key value pairs. Durations are given in
+// milliseconds.
+func (e *AccessEntry) Fields() map[string]interface{} {
+	ms := func(d time.Duration) float64 {
+		return float64(d.Microseconds()) / 1000
+	}
+
+	f := map[string]interface{}{
+		"method":       e.Method,
+		"path":         e.Path,
+		"status":       e.Status,
+		"bytes":        e.Bytes,
+		"source_size":  e.SourceSize,
+		"output_size":  e.OutputSize,
+		"fetch_ms":     ms(e.Fetch),
+		"decode_ms":    ms(e.Decode),
+		"transform_ms": ms(e.Transform),
+		"encode_ms":    ms(e.Encode),
+		"duration_ms":  ms(e.Duration),
+		"cache_hit":    e.CacheHit,
+		"shared":       e.Shared,
+	}
+
+	if e.Error != "" {
+		f["error"] = e.Error
+	}
+
+	return f
+}
+
+// String formats the entry as key=value pairs sorted by key.
+func (e *AccessEntry) String() string {
+	fields := e.Fields()
+	keys := make([]string, 0, len(fields))
+
+	for k := range fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+
+	for i, k := range keys {
+		pairs[i] = fmt.Sprintf("%s=%v", k, fields[k])
+
+		if k == "error" || k == "path" {
+			pairs[i] = fmt.Sprintf("%s=%q", k, fields[k])
+		}
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// statusClientClosed is logged for requests canceled by the client.
+const statusClientClosed = 499
+
+// AccessLogger is implemented by loggers writing structured access log
+// entries. Other loggers receive each entry as a text line.
+type AccessLogger interface {
+	Access(e *AccessEntry)
+}
+
+func (s *Server) logAccess(e *AccessEntry) {
+	if al, ok := s.logger.(AccessLogger); ok {
+		al.Access(e)
+		return
+	}
+
+	s.logger.Printf("access %s", e)
+}
+
+// filterStats records the work done by a job.
+type filterStats struct {
+	image.Stats
+	fetch      time.Duration
+	sourceSize int64
+}
+
+// accessWriter records the status and body size of a response.
+type accessWriter struct {
+	http.ResponseWriter
+	entry *AccessEntry
+}
+
+func (w *accessWriter) WriteHeader(code int) {
+	if w.entry.Status == 0 {
+		w.entry.Status = code
+	}
+
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *accessWriter) Write(p []byte) (int, error) {
+	if w.entry.Status == 0 {
+		w.entry.Status = http.StatusOK
+	}
+
+	n, err := w.ResponseWriter.Write(p)
+	w.entry.Bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/metrics"
+)
+
+// serverMetrics are the metrics of a Server.
+type serverMetrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	duration        *metrics.Histogram
+	phases          *metrics.Histogram
+	responseBytes   *metrics.Counter
+	sourceBytes     *metrics.Counter
+	operations      *metrics.Gauge
+	cache           *metrics.Counter
+	backendRequests *metrics.Counter
+	backendErrors   *metrics.Counter
+	backendDuration *metrics.Histogram
+}
+
+func newServerMetrics() *serverMetrics {
+	r := metrics.NewRegistry()
+
+	return &serverMetrics{
+		registry:        r,
+		requests:        r.NewCounter("imgfilter_requests_total", "Image requests by route and status code.", "route", "status"),
+		duration:        r.NewHistogram("imgfilter_request_duration_seconds", "Image request latency by route.", metrics.DefBuckets, "route"),
+		phases:          r.NewHistogram("imgfilter_phase_duration_seconds", "Time spent in the fetch, decode, transform and encode phases.", metrics.DefBuckets, "phase"),
+		responseBytes:   r.NewCounter("imgfilter_response_bytes_total", "Bytes written in image responses by route.", "route"),
+		sourceBytes:     r.NewCounter("imgfilter_source_bytes_total", "Bytes read from the backend."),
+		operations:      r.NewGauge("imgfilter_image_operations_in_flight", "ImageMagick operations in progress."),
+		cache:           r.NewCounter("imgfilter_cache_requests_total", "Cache lookups by result, hit or miss.", "result"),
+		backendRequests: r.NewCounter("imgfilter_backend_requests_total", "Backend calls by operation.", "op"),
+		backendErrors:   r.NewCounter("imgfilter_backend_errors_total", "Failed backend calls by operation and error code.", "op", "code"),
+		backendDuration: r.NewHistogram("imgfilter_backend_duration_seconds", "Backend call latency by operation.", metrics.DefBuckets, "op"),
+	}
+}
+
+// Metrics returns a handler serving the server metrics in the Prometheus
+// text format.
+func (s *Server) Metrics() http.Handler {
+	return s.metrics.registry
+}
+
+// observeRequest records a served request of the named route.
+func (m *serverMetrics) observeRequest(route string, e *AccessEntry) {
+	m.requests.Inc(route, strconv.Itoa(e.Status))
+	m.duration.Observe(e.Duration.Seconds(), route)
+	m.responseBytes.Add(float64(e.Bytes), route)
+
+	if e.Fetch > 0 {
+		m.phases.Observe(e.Fetch.Seconds(), "fetch")
+	}
+
+	// Requests sharing the result of another request did no image work.
+	if e.Decode > 0 {
+		m.phases.Observe(e.Decode.Seconds(), "decode")
+		m.phases.Observe(e.Transform.Seconds(), "transform")
+		m.phases.Observe(e.Encode.Seconds(), "encode")
+	}
+}
+
+// observeCache records a cache lookup.
+func (m *serverMetrics) observeCache(hit bool) {
+	if hit {
+		m.cache.Inc("hit")
+	} else {
+		m.cache.Inc("miss")
+	}
+}
+
+func (m *serverMetrics) observeBackend(op string, start time.Time, err error) {
+	m.backendRequests.Inc(op)
+	m.backendDuration.Observe(time.Since(start).Seconds(), op)
+
+	if err != nil {
+		_, code := errorStatus(err)
+		m.backendErrors.Inc(op, code)
+	}
+}
+
+// instrument wraps b so backend calls are recorded. The result implements
+// backend.StatBackend if b does.
+func (m *serverMetrics) instrument(b backend.ImageBackend) backend.ImageBackend {
+	mb := meteredBackend{b, m}
+
+	if sb, ok := b.(backend.StatBackend); ok {
+		return &meteredStatBackend{mb, sb}
+	}
+
+	return &mb
+}
+
+// meteredBackend records the calls of an ImageBackend.
+type meteredBackend struct {
+	backend.ImageBackend
+	m *serverMetrics
+}
+
+func (b *meteredBackend) ReadFile(name string) ([]byte, error) {
+	start := time.Now()
+	data, err := b.ImageBackend.ReadFile(name)
+	b.m.observeBackend("read", start, err)
+	b.m.sourceBytes.Add(float64(len(data)))
+	return data, err
+}
+
+// meteredStatBackend records the calls of a StatBackend.
+type meteredStatBackend struct {
+	meteredBackend
+	sb backend.StatBackend
+}
+
+func (b *meteredStatBackend) Stat(name string) (*backend.FileInfo, error) {
+	start := time.Now()
+	fi, err := b.sb.Stat(name)
+	b.m.observeBackend("stat", start, err)
+	return fi, err
+}
+
+// Open records the time until the file is opened. The bytes read are
+// recorded as they are read.
+func (b *meteredStatBackend) Open(name string) (io.ReadCloser, error) {
+	start := time.Now()
+	rc, err := b.sb.Open(name)
+	b.m.observeBackend("open", start, err)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &meteredReader{rc, b.m}, nil
+}
+
+type meteredReader struct {
+	io.ReadCloser
+	m *serverMetrics
+}
+
+func (r *meteredReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.m.sourceBytes.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,289 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/simonz05/imgfilter/image"
+)
+
+// maxPipelineOps limits the number of operations in a single pipeline.
+const maxPipelineOps = 16
+
+type pipelineOp struct {
+	name, arg string
+	fn        image.Operation
+}
+
+func (o pipelineOp) String() string {
+	return o.name + ":" + o.arg
+}
+
+func pipelineString(ops []pipelineOp) string {
+	s := make([]string, len(ops))
+
+	for i, o := range ops {
+		s[i] = o.String()
+	}
+
+	return strings.Join(s, "/")
+}
+
+// newPipelineOp returns the operation name with argument arg. ok is false
+// if name is not an operation.
+func newPipelineOp(name, arg string) (o pipelineOp, ok bool, err error) {
+	o.name = name
+
+	switch name {
+	case "crop", "resize", "thumbnail", "pad":
+		var g *image.Geometry
+
+		if g, err = image.ParseGeometry(arg); err != nil {
+			break
+		}
+
+		o.arg = g.String()
+
+		switch name {
+		case "crop":
+			o.fn = func(im *image.Image) error { return im.Crop(g) }
+		case "resize":
+			o.fn = func(im *image.Image) error { return im.Resize(g) }
+		case "thumbnail":
+			o.fn = func(im *image.Image) error { return im.Thumbnail(g) }
+		case "pad":
+			o.fn = func(im *image.Image) error { return im.Pad(g) }
+		}
+	case "gravity":
+		direction, valid, _ := parseDirection(arg)
+
+		if !valid {
+			err = fmt.Errorf("invalid gravity %q", arg)
+			break
+		}
+
+		o.arg = direction
+		o.fn = func(im *image.Image) error {
+			im.SetDirection(direction)
+			return nil
+		}
+	case "fp":
+		var fp *image.FocalPoint
+
+		if fp, err = image.ParseFocalPoint(arg); err != nil {
+			break
+		}
+
+		direction := fp.String()
+		o.arg = strings.TrimPrefix(direction, "fp:")
+		o.fn = func(im *image.Image) error {
+			im.SetDirection(direction)
+			return nil
+		}
+	case "rotate":
+		var degrees float64
+
+		if degrees, err = parseRotate(arg); err != nil {
+			break
+		}
+
+		o.arg = strconv.FormatFloat(degrees, 'g', -1, 64)
+		o.fn = func(im *image.Image) error { return im.Rotate(degrees) }
+	case "flip":
+		switch arg {
+		case "v":
+			o.fn = func(im *image.Image) error { return im.Flip() }
+		case "h":
+			o.fn = func(im *image.Image) error { return im.Flop() }
+		case "hv", "vh":
+			o.fn = func(im *image.Image) error {
+				if err := im.Flip(); err != nil {
+					return err
+				}
+
+				return im.Flop()
+			}
+		default:
+			err = fmt.Errorf("invalid flip %q", arg)
+		}
+
+		o.arg = arg
+
+		if arg == "vh" {
+			o.arg = "hv"
+		}
+	case "sharpen":
+		var radius, sigma float64
+
+		if radius, sigma, err = parseSharpen(arg); err != nil {
+			break
+		}
+
+		o.arg = strconv.FormatFloat(radius, 'g', -1, 64) + "x" + strconv.FormatFloat(sigma, 'g', -1, 64)
+		o.fn = func(im *image.Image) error { return im.Sharpen(radius, sigma) }
+	default:
+		return
+	}
+
+	return o, err == nil, err
+}
+
+// parseRotate parses a clockwise rotation in degrees between -360 and 360
+// and normalizes it to [0, 360).
+func parseRotate(v string) (float64, error) {
+	degrees, err := strconv.ParseFloat(v, 64)
+
+	if err != nil || !(degrees >= -360 && degrees <= 360) {
+		return 0, fmt.Errorf("invalid rotate %q", v)
+	}
+
+	if degrees = math.Mod(degrees, 360); degrees < 0 {
+		degrees += 360
+	}
+
+	return degrees, nil
+}
+
+// parseSharpen parses a sharpen argument of the form sigma or radiusxsigma.
+func parseSharpen(v string) (radius, sigma float64, err error) {
+	s := v
+
+	if i := strings.IndexByte(v, 'x'); i >= 0 {
+		if radius, err = strconv.ParseFloat(v[:i], 64); err != nil {
+			return 0, 0, fmt.Errorf("invalid sharpen %q", v)
+		}
+
+		s = v[i+1:]
+	}
+
+	if sigma, err = strconv.ParseFloat(s, 64); err != nil || radius < 0 || radius > 100 || sigma <= 0 || sigma > 100 {
+		return 0, 0, fmt.Errorf("invalid sharpen %q", v)
+	}
+
+	return
+}
+
+// parsePipeline parses v of the form op:arg/.../filepath. Encoder
+// parameters such as q:80 are collected in the params of the file info.
+// The first segment which is not an operation starts the file path.
+func parsePipeline(v string) (f *FileInfo, err error) {
+	f = &FileInfo{ops: []pipelineOp{}, params: make(url.Values)}
+
+	for {
+		i := strings.IndexByte(v, '/')
+		j := strings.IndexByte(v, ':')
+
+		if i < 0 || j < 0 || j > i {
+			break
+		}
+
+		name, arg := v[:j], v[j+1:i]
+
+		if encodeParams[name] {
+			f.params.Set(name, arg)
+		} else {
+			op, ok, err := newPipelineOp(name, arg)
+
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				break
+			}
+
+			f.ops = append(f.ops, op)
+		}
+
+		if len(f.ops)+len(f.params) > maxPipelineOps {
+			return nil, fmt.Errorf("pipeline exceeds %d operations", maxPipelineOps)
+		}
+
+		v = v[i+1:]
+	}
+
+	if len(f.ops) == 0 && len(f.params) == 0 {
+		return nil, errors.New("empty pipeline")
+	}
+
+	f.filepath = path.Clean(v)
+
+	if v == "" || f.filepath == "." || f.filepath == "/" {
+		return nil, errors.New("missing file path")
+	}
+
+	return f, nil
+}
+
+// PipelineFilter applies a sequence of operations to an image.
+type PipelineFilter struct{}
+
+func NewPipelineFilter() *PipelineFilter {
+	return &PipelineFilter{}
+}
+
+// SizeParser validates the operations of a pipeline.
+func (t *PipelineFilter) SizeParser(v string) (*FileInfo, error) {
+	return parsePipeline(v)
+}
+
+func (t *PipelineFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	return filterPipeline(data, f)
+}
+
+// OperationFilter applies a single pipeline operation taking its argument
+// from the first path segment, e.g. rotate in /rotate/90/a.png.
+type OperationFilter struct {
+	name string
+}
+
+func NewOperationFilter(name string) *OperationFilter {
+	return &OperationFilter{name}
+}
+
+// SizeParser validates the operation argument.
+func (t *OperationFilter) SizeParser(v string) (*FileInfo, error) {
+	i := strings.IndexByte(v, '/')
+
+	if i < 0 {
+		return nil, errors.New("string mismatch")
+	}
+
+	op, ok, err := newPipelineOp(t.name, v[:i])
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown operation %q", t.name)
+	}
+
+	f := &FileInfo{ops: []pipelineOp{op}, filepath: path.Clean(v[i+1:])}
+
+	if i == len(v)-1 || f.filepath == "." || f.filepath == "/" {
+		return nil, errors.New("missing file path")
+	}
+
+	return f, nil
+}
+
+func (t *OperationFilter) Filter(data []byte, f *FileInfo) ([]byte, error) {
+	return filterPipeline(data, f)
+}
+
+// filterPipeline applies the operations of f to an image.
+func filterPipeline(data []byte, f *FileInfo) ([]byte, error) {
+	ops := make([]image.Operation, len(f.ops))
+
+	for i, o := range f.ops {
+		ops[i] = o.fn
+	}
+
+	return image.PipelineStats(data, ops, &f.options, &f.stats.Stats)
+}
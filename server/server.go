@@ -23,69 +23,279 @@
 package server
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/cache"
+	"github.com/simonz05/imgfilter/image"
+	"github.com/simonz05/imgfilter/sign"
 	"github.com/simonz05/util/log"
 )
 
-var (
+// Options configures the imgfilter server.
+type Options struct {
+	// Backend stores the source images.
+	Backend backend.ImageBackend
+	// Cache stores filtered images if non-nil. Entries are keyed by the
+	// source version, so a source whose backend reports neither an ETag nor
+	// a modification time is served from the cache until its entry is
+	// evicted.
+	Cache cache.Cache
+	// CacheControl sets the Cache-Control header of image responses.
+	CacheControl CacheControl
+	// Signer restricts the server to signed URLs if non-nil.
+	Signer *sign.Signer
+	// Formats are the output formats negotiated from the Accept header,
+	// in order of preference.
+	Formats []image.Format
+	// Quality is the default quality of lossy formats. Zero uses the
+	// ImageMagick default.
+	Quality uint
+	// MaxQuality caps the quality requested by clients if non-zero.
+	MaxQuality uint
+	// Background is the color transparent images are flattened against
+	// when the output format lacks transparency. White if empty.
+	Background string
+	// KeepOrientation keeps the EXIF orientation of source images instead
+	// of rotating and flipping them accordingly.
+	KeepOrientation bool
+	// Limits restricts the source and output images. Larger sources are
+	// rejected with 413 Request Entity Too Large and larger outputs with
+	// 422 Unprocessable Entity.
+	Limits image.Limits
+	// JSONErrors writes error responses as JSON objects with a stable
+	// error code and a message.
+	JSONErrors bool
+	// Logger receives the server log. The util/log package is used if nil.
+	// Access log entries are written as text lines unless Logger
+	// implements AccessLogger.
+	Logger Logger
+	// MaxConcurrency limits the number of concurrent image operations if
+	// positive.
+	MaxConcurrency int
+	// MaxQueue is the number of requests waiting for an image operation
+	// slot. Further requests are rejected with 503 Service Unavailable.
+	MaxQueue int
+	// ProbeFile is looked up in the backend by the readiness endpoint
+	// /readyz. The backend is not checked if empty.
+	ProbeFile string
+	// MetricsPath is the path of the Prometheus metrics endpoint. The
+	// endpoint is disabled if empty, the metrics are still available from
+	// Server.Metrics.
+	MetricsPath string
+	// ShutdownTimeout is the time ListenAndServe waits for in-flight
+	// requests on shutdown. Zero waits indefinitely.
+	ShutdownTimeout time.Duration
+}
+
+// Logger is the logging interface of the server.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+type defaultLogger struct{}
+
+func (defaultLogger) Printf(format string, v ...interface{}) {
+	log.Printf(format, v...)
+}
+
+func (defaultLogger) Errorf(format string, v ...interface{}) {
+	log.Errorf(format, v...)
+}
+
+// Server is an imgfilter HTTP handler. Each Server has its own router,
+// backend and configuration, so several servers can be used in one
+// process.
+type Server struct {
 	router       *mux.Router
-	imageBackend backend.ImageBackend
-)
+	backend      backend.ImageBackend
+	cache        cache.Cache
+	cacheControl CacheControl
+	signer       *sign.Signer
+	formats      []image.Format
+	quality      uint
+	maxQuality   uint
+	background   string
+	keepOrient   bool
+	limits       image.Limits
+	filters      map[string]ImageFilter
+	jsonErrors   bool
+	logger       Logger
+	inflight     sync.WaitGroup
+	limiter      *limiter
+	flights      flightGroup
+	metrics      *serverMetrics
+	probeFile    string
+}
 
-func sigTrapCloser(l net.Listener) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGINT, syscall.SIGKILL, syscall.SIGTERM, syscall.SIGHUP)
+// New returns a Server configured by opt.
+func New(opt Options) (*Server, error) {
+	if opt.Backend == nil {
+		return nil, errors.New("server: backend required")
+	}
 
-	go func() {
-		for _ = range c {
-			// Once we close the listener the main loop will exit
-			l.Close()
-			log.Printf("Closed listener %s", l.Addr())
+	var background string
+
+	if opt.Background != "" {
+		bg, err := image.ParseColor(opt.Background)
+
+		if err != nil {
+			return nil, fmt.Errorf("server: background: %v", err)
 		}
-	}()
-}
 
-func makeCropHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		imageHandle(w, r, NewCropFilter())
+		background = bg
 	}
-}
 
-func makeResizeHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		imageHandle(w, r, NewResizeFilter())
+	m := newServerMetrics()
+
+	s := &Server{
+		router:       mux.NewRouter(),
+		backend:      m.instrument(opt.Backend),
+		cache:        opt.Cache,
+		cacheControl: opt.CacheControl,
+		signer:       opt.Signer,
+		formats:      opt.Formats,
+		quality:      opt.Quality,
+		maxQuality:   opt.MaxQuality,
+		background:   background,
+		keepOrient:   opt.KeepOrientation,
+		limits:       opt.Limits,
+		jsonErrors:   opt.JSONErrors,
+		logger:       opt.Logger,
+		limiter:      newLimiter(opt.MaxConcurrency, opt.MaxQueue),
+		metrics:      m,
+		probeFile:    opt.ProbeFile,
+		filters: map[string]ImageFilter{
+			"crop":      NewCropFilter(),
+			"resize":    NewResizeFilter(),
+			"thumbnail": NewThumbnailFilter(),
+			"pad":       NewPadFilter(),
+			"rotate":    NewOperationFilter("rotate"),
+			"flip":      NewOperationFilter("flip"),
+			"pipeline":  NewPipelineFilter(),
+		},
 	}
-}
 
-func makeThumbnailHandler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		imageHandle(w, r, NewThumbnailFilter())
+	if s.logger == nil {
+		s.logger = defaultLogger{}
 	}
-}
 
-func setupServer(b backend.ImageBackend) error {
 	// HTTP endpoints
-	imageBackend = b
+	s.handleFilter("/crop/{fileinfo:.*}", "crop")
+	s.handleFilter("/resize/{fileinfo:.*}", "resize")
+	s.handleFilter("/thumbnail/{fileinfo:.*}", "thumbnail")
+	s.handleFilter("/pad/{fileinfo:.*}", "pad")
+	s.handleFilter("/rotate/{fileinfo:.*}", "rotate")
+	s.handleFilter("/flip/{fileinfo:.*}", "flip")
+	s.handleFilter("/p/{fileinfo:.*}", "pipeline")
+
+	s.router.HandleFunc("/info/{filepath:.*}", s.infoHandle).Methods("GET").Name("info")
+	s.router.HandleFunc("/healthz", s.healthz).Methods("GET").Name("healthz")
+	s.router.HandleFunc("/readyz", s.readyz).Methods("GET").Name("readyz")
+
+	if opt.MetricsPath != "" {
+		s.router.Handle(opt.MetricsPath, s.Metrics()).Methods("GET").Name("metrics")
+	}
+
+	s.router.StrictSlash(false)
+
+	return s, nil
+}
+
+func (s *Server) handleFilter(tpl, name string) {
+	f := s.filters[name]
+
+	s.router.HandleFunc(tpl, func(w http.ResponseWriter, r *http.Request) {
+		s.imageHandle(w, r, name, f)
+	}).Methods("GET").Name(name)
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// Shutdown waits for in-flight requests and image operations to finish,
+// including operations of requests canceled by their clients, and closes
+// the cache if it implements io.Closer. It returns the context's error if
+// ctx is done first. Shutdown does not stop new requests; shut down the
+// http.Server serving s first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		// Requests start flights, so no flight starts once they are done.
+		s.inflight.Wait()
+		s.flights.wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	router = mux.NewRouter()
-	router.HandleFunc("/crop/{fileinfo:.*}", makeCropHandler()).Methods("GET").Name("crop")
-	router.HandleFunc("/resize/{fileinfo:.*}", makeResizeHandler()).Methods("GET").Name("resize")
-	router.HandleFunc("/thumbnail/{fileinfo:.*}", makeThumbnailHandler()).Methods("GET").Name("thumbnail")
-	router.StrictSlash(false)
-	http.Handle("/", router)
+	if c, ok := s.cache.(io.Closer); ok {
+		return c.Close()
+	}
 
 	return nil
 }
 
-func ListenAndServe(laddr string, imgBackend backend.ImageBackend) error {
-	if err := setupServer(imgBackend); err != nil {
+// sigTrapShutdown shuts down srv and s once a signal is received. The
+// result is sent on the returned channel.
+func (s *Server) sigTrapShutdown(srv *http.Server, timeout time.Duration) <-chan error {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
+	done := make(chan error, 1)
+
+	go func() {
+		sig := <-c
+		signal.Stop(c)
+		s.logger.Printf("Received %v, shutting down ..", sig)
+
+		ctx := context.Background()
+
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		// Once the listener is closed the main loop will exit
+		err := srv.Shutdown(ctx)
+
+		if err == nil {
+			err = s.Shutdown(ctx)
+		}
+
+		done <- err
+	}()
+
+	return done
+}
+
+// ListenAndServe starts an imgfilter server configured by opt on laddr. On
+// SIGINT or SIGTERM the server stops accepting connections and
+// waits up to opt.ShutdownTimeout for in-flight requests. A nil error is
+// returned once all requests are done.
+func ListenAndServe(laddr string, opt Options) error {
+	s, err := New(opt)
+
+	if err != nil {
 		return err
 	}
 
@@ -95,10 +305,19 @@ func ListenAndServe(laddr string, imgBackend backend.ImageBackend) error {
 		return err
 	}
 
-	log.Printf("Listen on %s", l.Addr())
+	s.logger.Printf("Listen on %s", l.Addr())
 
-	sigTrapCloser(l)
-	err = http.Serve(l, nil)
-	log.Printf("Shutting down ..")
-	return err
+	srv := &http.Server{Handler: s}
+	done := s.sigTrapShutdown(srv, opt.ShutdownTimeout)
+
+	if err = srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+
+	if err = <-done; err != nil {
+		return err
+	}
+
+	s.logger.Printf("Shut down")
+	return nil
 }
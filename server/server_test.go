@@ -1,16 +1,29 @@
 package server
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	goimage "image"
+	_ "image/png"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/simonz05/imgfilter/backend"
+	"github.com/simonz05/imgfilter/cache"
+	"github.com/simonz05/imgfilter/image"
+	"github.com/simonz05/imgfilter/sign"
 )
 
 var (
@@ -20,13 +33,941 @@ var (
 )
 
 func startServer() {
-	fs := NewFileSystem("")
-	err := setupServer("travis@tcp(localhost:3306)/myapp_test?charset=utf8", fs)
+	s, err := New(Options{Backend: backend.Dir("../image/fixture")})
 
 	if err != nil {
 		panic(err)
 	}
 
-	server = httptest.NewServer(nil)
+	server = httptest.NewServer(s)
 	serverAddr = server.Listener.Addr().String()
 }
+
+func get(t *testing.T, path string, header http.Header) *http.Response {
+	once.Do(startServer)
+	req, err := http.NewRequest("GET", fmt.Sprintf("http://%s%s", serverAddr, path), nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res.Body.Close()
+	return res
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Options{}); err == nil {
+		t.Fatal("expected error without backend")
+	}
+
+	if _, err := New(Options{Backend: backend.Dir("../image/fixture"), Background: "notacolor"}); err == nil {
+		t.Fatal("expected error for invalid background")
+	}
+}
+
+func TestParseFileInfo(t *testing.T) {
+	tests := []struct {
+		v             string
+		withDirection bool
+		geometry      string
+		direction     string
+		filepath      string
+	}{
+		{"640x480!/a.png", false, "640x480!", "", "a.png"},
+		{"200x/dir/a.png", false, "200", "", "dir/a.png"},
+		{"x256/north/a.png", false, "x256", "", "north/a.png"},
+		{"x256/north/a.png", true, "x256", "north", "a.png"},
+		{"300x200/smart/a.png", true, "300x200", "smart", "a.png"},
+		{"300x200/entropy/dir/a.png", true, "300x200", "entropy", "dir/a.png"},
+		{"300x200^/fp:0.30,.7/a.png", true, "300x200^", "fp:0.3,0.7", "a.png"},
+		{"100x100+10+10/a.png", true, "100x100+10+10", "", "a.png"},
+		{"50%/../a.png", false, "50%", "", "../a.png"},
+	}
+
+	for _, x := range tests {
+		f, err := parseFileInfo(x.v, x.withDirection)
+
+		if err != nil {
+			t.Fatalf("%s: %v", x.v, err)
+		}
+
+		if f.geometry.String() != x.geometry || f.direction != x.direction || f.filepath != x.filepath {
+			t.Fatalf("%s: expected %s:%s:%s got %s:%s:%s", x.v, x.geometry, x.direction, x.filepath,
+				f.geometry, f.direction, f.filepath)
+		}
+	}
+
+	if f, _ := parseFileInfo("100x100/a.png", false); strings.Contains(f.String(), "\n") {
+		t.Fatalf("unexpected newline in %q", f)
+	}
+
+	for _, v := range []string{"", "100x100", "100x100/", "abc/a.png", "!/a.png", "300x200/fp:2,0/a.png", "300x200/fp:2,0.5/a.png", "300x200/fp:x/a.png"} {
+		if _, err := parseFileInfo(v, true); err == nil {
+			t.Fatalf("%q: expected error", v)
+		}
+	}
+}
+
+func TestParsePipeline(t *testing.T) {
+	f, err := parsePipeline("crop:100x100+10+10/gravity:north/resize:50x50/sharpen:1/q:80/fm:webp/dir/a:b.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := pipelineString(f.ops); s != "crop:100x100+10+10/gravity:north/resize:50x50/sharpen:0x1" {
+		t.Fatalf("unexpected pipeline %s", s)
+	}
+
+	if !reflect.DeepEqual(f.params, url.Values{"q": {"80"}, "fm": {"webp"}}) {
+		t.Fatalf("unexpected params %v", f.params)
+	}
+
+	if f.filepath != "dir/a:b.png" {
+		t.Fatalf("unexpected file path %s", f.filepath)
+	}
+
+	f, err = parsePipeline("fp:0.50,0.25/thumbnail:100x100^/gravity:fp:1,0/crop:10x10/a.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := pipelineString(f.ops); s != "fp:0.5,0.25/thumbnail:100x100^/gravity:fp:1,0/crop:10x10" {
+		t.Fatalf("unexpected pipeline %s", s)
+	}
+
+	f, err = parsePipeline("rotate:-90/rotate:360/flip:vh/flip:h/a.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s := pipelineString(f.ops); s != "rotate:270/rotate:0/flip:hv/flip:h" {
+		t.Fatalf("unexpected pipeline %s", s)
+	}
+
+	for _, v := range []string{"a.png", "resize:abc/a.png", "gravity:up/a.png", "rotate:NaN/a.png", "flip:/a.png", "sharpen:-1/a.png", "crop:10x10/", "fp:0.5/a.png", "fp:0.5,-1/a.png"} {
+		if _, err := parsePipeline(v); err == nil {
+			t.Fatalf("%q: expected error", v)
+		}
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	s := &Server{formats: []image.Format{image.FormatWebP}}
+
+	tests := []struct {
+		query      url.Values
+		accept     string
+		format     image.Format
+		negotiated bool
+	}{
+		{url.Values{"fm": {"png"}}, "image/webp", image.FormatPNG, false},
+		{nil, "image/avif,image/webp,image/*,*/*;q=0.8", image.FormatWebP, true},
+		{nil, "image/webp;q=0", "", true},
+		{nil, "image/*", "", true},
+	}
+
+	for _, x := range tests {
+		f, negotiated, err := s.outputFormat(x.query, x.accept)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f != x.format || negotiated != x.negotiated {
+			t.Fatalf("%v %s: expected %s %t got %s %t", x.query, x.accept, x.format, x.negotiated, f, negotiated)
+		}
+	}
+}
+
+func TestParseEncodeOptions(t *testing.T) {
+	s := &Server{quality: 80, maxQuality: 90}
+
+	tests := []struct {
+		query string
+		opt   image.EncodeOptions
+		err   bool
+	}{
+		{"", image.EncodeOptions{Quality: 80}, false},
+		{"q=50", image.EncodeOptions{Quality: 50}, false},
+		{"q=95", image.EncodeOptions{Quality: 90}, false},
+		{"q=0", image.EncodeOptions{}, true},
+		{"q=101", image.EncodeOptions{}, true},
+		{"chroma=444", image.EncodeOptions{Quality: 80, Subsampling: "4:4:4"}, false},
+		{"chroma=4:2:0", image.EncodeOptions{Quality: 80, Subsampling: "4:2:0"}, false},
+		{"chroma=411", image.EncodeOptions{}, true},
+		{"compression=9", image.EncodeOptions{Quality: 80, Compression: 9}, false},
+		{"compression=0", image.EncodeOptions{}, true},
+		{"compression=10", image.EncodeOptions{}, true},
+		{"strip=true", image.EncodeOptions{Quality: 80, Strip: true}, false},
+		{"strip=maybe", image.EncodeOptions{}, true},
+		{"progressive=1", image.EncodeOptions{Quality: 80, Progressive: true}, false},
+		{"progressive=maybe", image.EncodeOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		query, err := url.ParseQuery(tt.query)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var opt image.EncodeOptions
+		err = s.parseEncodeOptions(query, &opt)
+
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected error", tt.query)
+			}
+
+			continue
+		}
+
+		if err != nil || opt != tt.opt {
+			t.Errorf("%q: expected %+v got %+v %v", tt.query, tt.opt, opt, err)
+		}
+	}
+
+	// Without a maximum the quality is not capped.
+	var opt image.EncodeOptions
+
+	if err := (&Server{}).parseEncodeOptions(url.Values{"q": {"100"}}, &opt); err != nil || opt.Quality != 100 {
+		t.Fatalf("expected quality 100 got %d %v", opt.Quality, err)
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	modTime := time.Date(2013, 10, 1, 12, 0, 0, 0, time.UTC)
+	tag := etag("key")
+
+	tests := []struct {
+		header   http.Header
+		modified bool
+	}{
+		{http.Header{}, true},
+		{http.Header{"If-None-Match": {tag}}, false},
+		{http.Header{"If-None-Match": {`"other", W/` + tag}}, false},
+		{http.Header{"If-None-Match": {`"other"`}}, true},
+		{http.Header{"If-None-Match": {"*"}}, false},
+		{http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}}, false},
+		{http.Header{"If-Modified-Since": {modTime.Add(-time.Second).Format(http.TimeFormat)}}, true},
+		{http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {modTime.Format(http.TimeFormat)}}, true},
+	}
+
+	for _, x := range tests {
+		r := &http.Request{Header: x.header}
+
+		if checkNotModified(r, tag, modTime) == x.modified {
+			t.Fatalf("%v: expected modified %t", x.header, x.modified)
+		}
+	}
+}
+
+func TestImageHandleBadRequest(t *testing.T) {
+	for _, path := range []string{
+		"/thumbnail/abc/circle.png",
+		"/resize/100x100/circle.png?fm=bmp",
+		"/resize/100x100/circle.png?q=101",
+		"/pad/100x100/circle.png?bg=red1",
+		"/pad/100x100/circle.png?bg=notacolor",
+		"/rotate/abc/circle.png",
+		"/rotate/720/circle.png",
+		"/rotate/90/",
+		"/crop/300x200/fp:2,0.5/circle.png",
+		"/crop/300x200/fp:x/circle.png",
+		"/flip/x/circle.png",
+	} {
+		if res := get(t, path, nil); res.StatusCode != 400 {
+			t.Fatalf("%s: expected 400 got %d", path, res.StatusCode)
+		}
+	}
+}
+
+func TestImageHandleCorruptSource(t *testing.T) {
+	data, err := ioutil.ReadFile("../image/fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "imgfilter-server")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(dir+"/truncated.png", data[:len(data)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{Backend: backend.Dir(dir), Logger: new(accessRecorder)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/resize/10x10/truncated.png", nil))
+
+	if w.Code != 500 {
+		t.Fatalf("expected 500 got %d", w.Code)
+	}
+}
+
+func TestImageHandleNotFound(t *testing.T) {
+	if res := get(t, "/resize/100x100/missing.png", nil); res.StatusCode != 404 {
+		t.Fatalf("expected 404 got %d", res.StatusCode)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+	}{
+		{&requestError{errors.New("invalid geometry")}, 400, "bad_request"},
+		{&requestError{fmt.Errorf("%w %q", image.ErrUnsupportedFormat, "avif")}, 415, "unsupported_format"},
+		{sign.ErrExpired, 403, "expired_signature"},
+		{fmt.Errorf("%w: missing.png", backend.ErrNotFound), 404, "not_found"},
+		{fmt.Errorf("%w: timeout", backend.ErrUnavailable), 502, "backend_unavailable"},
+		{fmt.Errorf("%w: 1 bytes", image.ErrTooLarge), 413, "source_too_large"},
+		{errBusy, 503, "busy"},
+		{errors.New("magick wand failure"), 500, "internal"},
+	}
+
+	for _, tt := range tests {
+		status, code := errorStatus(tt.err)
+
+		if status != tt.status || code != tt.code {
+			t.Errorf("%v: expected %d %s got %d %s", tt.err, tt.status, tt.code, status, code)
+		}
+	}
+}
+
+func TestWriteErrorJSON(t *testing.T) {
+	s, err := New(Options{Backend: backend.Dir("../image/fixture"), JSONErrors: true})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/resize/100x100/missing.png", nil))
+
+	var body errorBody
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != 404 || body.Code != "not_found" || body.Message == "" {
+		t.Fatalf("unexpected response %d %+v", w.Code, body)
+	}
+}
+
+func TestImageHandle(t *testing.T) {
+	for _, path := range []string{
+		"/thumbnail/100x100/circle.png",
+		"/resize/50%25/circle.png",
+		"/resize/50%25/circle.png?bg=white",
+		"/crop/100x100+10+10/north/circle.png",
+		"/pad/300x200/west/circle.png?bg=ff0000",
+		"/p/crop:200x200/resize:50x50/sharpen:1/circle.png",
+		"/p/pad:100x50/bg:none/circle.png",
+		"/rotate/90/circle.png",
+		"/rotate/-22.5/circle.png?bg=white",
+		"/flip/h/circle.png",
+		"/p/rotate:270/flip:vh/crop:10x10/circle.png",
+	} {
+		res := get(t, path, nil)
+
+		if res.StatusCode != 200 {
+			t.Fatalf("%s: expected 200 got %d", path, res.StatusCode)
+		}
+
+		if ct := res.Header.Get("Content-Type"); ct != "image/png" {
+			t.Fatalf("%s: expected image/png got %s", path, ct)
+		}
+
+		tag := res.Header.Get("Etag")
+
+		if tag == "" {
+			t.Fatalf("%s: expected ETag", path)
+		}
+
+		if res = get(t, path, http.Header{"If-None-Match": {tag}}); res.StatusCode != 304 {
+			t.Fatalf("%s: expected 304 got %d", path, res.StatusCode)
+		}
+	}
+}
+
+func TestImageHandleOriginNotFound(t *testing.T) {
+	origin := httptest.NewServer(http.NotFoundHandler())
+	defer origin.Close()
+
+	b, err := backend.NewHTTP(backend.HTTPOptions{BaseURL: origin.URL})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{Backend: b})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/resize/100x100/missing.png", nil))
+
+	if w.Code != 404 {
+		t.Fatalf("expected 404 got %d", w.Code)
+	}
+}
+
+func TestImageHandleOriginWithoutHead(t *testing.T) {
+	data, err := ioutil.ReadFile("../image/fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		w.Write(data)
+	}))
+	defer origin.Close()
+
+	b, err := backend.NewHTTP(backend.HTTPOptions{BaseURL: origin.URL})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(Options{Backend: b, Logger: new(accessRecorder)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/resize/100x100/circle.png", nil))
+
+	if w.Code != 200 || w.Header().Get("Etag") == "" {
+		t.Fatalf("expected 200 with ETag got %d", w.Code)
+	}
+}
+
+// readCounter counts the files read. It does not implement StatBackend.
+type readCounter struct {
+	dir   backend.Dir
+	reads int
+}
+
+func (b *readCounter) ReadFile(name string) ([]byte, error) {
+	b.reads++
+	return b.dir.ReadFile(name)
+}
+
+func TestImageHandleCacheBeforeRead(t *testing.T) {
+	b := &readCounter{dir: backend.Dir("../image/fixture")}
+	s, err := New(Options{Backend: b, Cache: cache.NewMemory(1 << 20), Logger: new(accessRecorder)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/resize/100x100/circle.png", "/resize/100x100/circle.png", "/info/circle.png", "/info/circle.png"} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != 200 {
+			t.Fatalf("%s: expected 200 got %d", path, w.Code)
+		}
+	}
+
+	if b.reads != 2 {
+		t.Fatalf("expected 2 reads got %d", b.reads)
+	}
+}
+
+type accessRecorder struct {
+	mu      sync.Mutex
+	entries []*AccessEntry
+}
+
+func (l *accessRecorder) Printf(format string, v ...interface{}) {}
+func (l *accessRecorder) Errorf(format string, v ...interface{}) {}
+
+func (l *accessRecorder) Access(e *AccessEntry) {
+	l.mu.Lock()
+	l.entries = append(l.entries, e)
+	l.mu.Unlock()
+}
+
+func TestAccessLog(t *testing.T) {
+	l := new(accessRecorder)
+	s, err := New(Options{Backend: backend.Dir("../image/fixture"), Logger: l})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/resize/10x10/circle.png", "/resize/10x10/missing.png"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if len(l.entries) != 2 {
+		t.Fatalf("expected 2 entries got %d", len(l.entries))
+	}
+
+	ok, missing := l.entries[0], l.entries[1]
+
+	if ok.Method != "GET" || ok.Status != 200 || ok.SourceSize == 0 || ok.OutputSize == 0 || ok.Bytes != int64(ok.OutputSize) || ok.CacheHit {
+		t.Fatalf("unexpected entry %s", ok)
+	}
+
+	if missing.Status != 404 || missing.Error == "" {
+		t.Fatalf("unexpected entry %s", missing)
+	}
+}
+
+func TestAccessLogPath(t *testing.T) {
+	tests := map[string]string{
+		"/resize/10x10/a.png":                 "/resize/10x10/a.png",
+		"/resize/10x10/a.png?q=80":            "/resize/10x10/a.png?q=80",
+		"/resize/10x10/a.png?s=abc":           "/resize/10x10/a.png",
+		"/resize/10x10/a.png?q=80&s=abc&e=10": "/resize/10x10/a.png?e=10&q=80",
+	}
+
+	for uri, expected := range tests {
+		u, err := url.ParseRequestURI(uri)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if p := logPath(u); p != expected {
+			t.Errorf("%s: expected %s got %s", uri, expected, p)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	s, err := New(Options{
+		Backend:     backend.Dir("../image/fixture"),
+		Cache:       cache.NewMemory(1 << 20),
+		Logger:      new(accessRecorder),
+		MetricsPath: "/metrics",
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{"/resize/10x10/circle.png", "/resize/10x10/circle.png", "/resize/10x10/missing.png"} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+
+	for _, want := range []string{
+		`imgfilter_requests_total{route="resize",status="200"} 2`,
+		`imgfilter_requests_total{route="resize",status="404"} 1`,
+		`imgfilter_cache_requests_total{result="hit"} 1`,
+		`imgfilter_cache_requests_total{result="miss"} 1`,
+		`imgfilter_backend_errors_total{op="stat",code="not_found"} 1`,
+		`imgfilter_phase_duration_seconds_count{phase="decode"} 1`,
+		`imgfilter_image_operations_in_flight 0`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("expected %s in\n%s", want, body)
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		path      string
+		probeFile string
+		code      int
+		status    string
+		checks    int
+	}{
+		{"/healthz", "", 200, "ok", 0},
+		{"/readyz", "", 200, "ok", 1},
+		{"/readyz", "circle.png", 200, "ok", 2},
+		{"/readyz", "missing.png", 503, "error", 2},
+	}
+
+	for _, tt := range tests {
+		s, err := New(Options{Backend: backend.Dir("../image/fixture"), Logger: new(accessRecorder), ProbeFile: tt.probeFile})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		var body healthBody
+
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+
+		if w.Code != tt.code || body.Status != tt.status || len(body.Checks) != tt.checks {
+			t.Fatalf("%s %s: unexpected response %d %+v", tt.path, tt.probeFile, w.Code, body)
+		}
+	}
+}
+
+// stallingBackend blocks Stat until release is closed.
+type stallingBackend struct {
+	backend.Dir
+	release chan struct{}
+}
+
+func (b *stallingBackend) Stat(name string) (*backend.FileInfo, error) {
+	<-b.release
+	return b.Dir.Stat(name)
+}
+
+func TestReadyzBounded(t *testing.T) {
+	defer func(d time.Duration) { checkTimeout = d }(checkTimeout)
+	checkTimeout = 50 * time.Millisecond
+
+	b := &stallingBackend{backend.Dir("../image/fixture"), make(chan struct{})}
+	defer close(b.release)
+
+	s, err := New(Options{Backend: b, Logger: new(accessRecorder), ProbeFile: "circle.png"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	var body healthBody
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != 503 || body.Checks["imagemagick"].Status != "ok" || body.Checks["backend"].Status != "error" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body)
+	}
+
+	if ms := body.Checks["backend"].LatencyMS; ms > 1000 {
+		t.Fatalf("expected the backend check to time out, took %gms", ms)
+	}
+}
+
+func TestReadyzBusy(t *testing.T) {
+	s, err := New(Options{Backend: backend.Dir("../image/fixture"), Logger: new(accessRecorder), ProbeFile: "circle.png", MaxConcurrency: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// All image operation slots are taken.
+	if err := s.limiter.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	defer s.limiter.release()
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/readyz", nil))
+
+	if w.Code != 200 {
+		t.Fatalf("expected 200 with all slots taken got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	l := new(accessRecorder)
+	s, err := New(Options{
+		Backend:      backend.Dir("../image/fixture"),
+		Cache:        cache.NewMemory(1 << 20),
+		CacheControl: CacheControl{MaxAge: time.Hour},
+		Logger:       l,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/circle.png?thumbnail=200x100", nil))
+
+	var body infoBody
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if w.Code != 200 || body.Width != 400 || body.Height != 400 || body.Format != image.FormatPNG || body.Size == 0 {
+		t.Fatalf("unexpected response %d %+v", w.Code, body)
+	}
+
+	if out := body.Output["thumbnail"]; out == nil || out.Width != 200 || out.Height != 100 {
+		t.Fatalf("unexpected output %+v", body.Output)
+	}
+
+	tag := w.Header().Get("Etag")
+
+	if tag == "" || w.Header().Get("Cache-Control") == "" {
+		t.Fatalf("expected cache headers got %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/circle.png?thumbnail=200x100", nil))
+
+	if e := l.entries[len(l.entries)-1]; w.Code != 200 || !e.CacheHit || w.Header().Get("Etag") != tag {
+		t.Fatalf("expected a cached response got %d %+v", w.Code, e)
+	}
+
+	req := httptest.NewRequest("GET", "/info/circle.png?thumbnail=200x100", nil)
+	req.Header.Set("If-None-Match", tag)
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+
+	if w.Code != 304 {
+		t.Fatalf("expected 304 got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/circle.png?thumbnail=100x100", nil))
+
+	if w.Header().Get("Etag") == tag {
+		t.Fatal("expected the geometry in the ETag")
+	}
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "/info/orient-6.jpg", nil))
+	body = infoBody{}
+
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Width != 32 || body.Height != 64 || body.Orientation != 6 {
+		t.Fatalf("expected oriented size got %+v", body)
+	}
+
+	for path, code := range map[string]int{
+		"/info/missing.png":              404,
+		"/info/":                         400,
+		"/info/circle.png?thumbnail=abc": 400,
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+
+		if w.Code != code {
+			t.Fatalf("%s: expected %d got %d", path, code, w.Code)
+		}
+	}
+}
+
+// blockingBackend blocks Open until release is closed.
+type blockingBackend struct {
+	backend.Dir
+	opened  chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingBackend) Open(name string) (io.ReadCloser, error) {
+	close(b.opened)
+	<-b.release
+	return b.Dir.Open(name)
+}
+
+func TestShutdownWaitsForDetachedFlights(t *testing.T) {
+	b := &blockingBackend{backend.Dir("../image/fixture"), make(chan struct{}), make(chan struct{})}
+	s, err := New(Options{Backend: b, Logger: new(accessRecorder)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		r := httptest.NewRequest("GET", "/resize/10x10/circle.png", nil).WithContext(ctx)
+		s.ServeHTTP(httptest.NewRecorder(), r)
+		close(done)
+	}()
+
+	// Cancel the only waiter while its flight is reading the source.
+	<-b.opened
+	cancel()
+	<-done
+
+	tctx, tcancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer tcancel()
+
+	if err := s.Shutdown(tctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected shutdown to wait for the flight, got %v", err)
+	}
+
+	close(b.release)
+
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPipelineCropChain(t *testing.T) {
+	data, err := ioutil.ReadFile("../image/fixture/circle.png")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		v    string
+		w, h int
+	}{
+		{"crop:100x100+50+50/crop:10x10/circle.png", 10, 10},
+		{"crop:100x100+300+300/crop:50x50+10+10/circle.png", 50, 50},
+		{"thumbnail:200x100^/crop:20x20+180+80/circle.png", 20, 20},
+		{"crop:300x300/pad:400x200/circle.png", 400, 200},
+	}
+
+	for _, tt := range tests {
+		f, err := parsePipeline(tt.v)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.v, err)
+		}
+
+		out, err := filterPipeline(data, f)
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.v, err)
+		}
+
+		if w, h := pngSize(t, out); w != tt.w || h != tt.h {
+			t.Errorf("%s: expected %dx%d got %dx%d", tt.v, tt.w, tt.h, w, h)
+		}
+
+		w := httptest.NewRecorder()
+		newTestServer(t).ServeHTTP(w, httptest.NewRequest("GET", "/p/"+tt.v, nil))
+
+		if w.Code != 200 {
+			t.Fatalf("/p/%s: expected 200 got %d", tt.v, w.Code)
+		}
+
+		if w, h := pngSize(t, w.Body.Bytes()); w != tt.w || h != tt.h {
+			t.Errorf("/p/%s: expected %dx%d got %dx%d", tt.v, tt.w, tt.h, w, h)
+		}
+	}
+}
+
+// pngSize returns the size of an encoded image.
+func pngSize(t *testing.T, data []byte) (int, int) {
+	c, _, err := goimage.DecodeConfig(bytes.NewReader(data))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c.Width, c.Height
+}
+
+func newTestServer(t *testing.T) *Server {
+	s, err := New(Options{Backend: backend.Dir("../image/fixture"), Logger: new(accessRecorder)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		limits image.Limits
+		path   string
+		code   int
+	}{
+		{image.Limits{MaxBytes: 10}, "/resize/100x100/circle.png", 413},
+		{image.Limits{MaxBytes: 10}, "/info/circle.png", 413},
+		{image.Limits{MaxPixels: 1000}, "/resize/100x100/circle.png", 413},
+		{image.Limits{MaxWidth: 50, MaxHeight: 50}, "/resize/100x100!/circle.png", 422},
+		{image.Limits{MaxWidth: 50, MaxHeight: 50}, "/resize/50x50/circle.png", 200},
+		{image.Limits{MaxBytes: 1 << 20}, "/resize/100x100/circle.png", 200},
+	}
+
+	for _, tt := range tests {
+		s, err := New(Options{Backend: backend.Dir("../image/fixture"), Logger: new(accessRecorder), Limits: tt.limits})
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+
+		if w.Code != tt.code {
+			t.Errorf("%s %+v: expected %d got %d", tt.path, tt.limits, tt.code, w.Code)
+		}
+	}
+}
+
+func TestOptionsETag(t *testing.T) {
+	tags := map[string]bool{}
+
+	for _, opt := range []Options{
+		{},
+		{Background: "black"},
+		{KeepOrientation: true},
+	} {
+		opt.Backend = backend.Dir("../image/fixture")
+		opt.Logger = new(accessRecorder)
+		s, err := New(opt)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest("GET", "/resize/100x100/circle.png?fm=jpeg", nil))
+
+		if w.Code != 200 {
+			t.Fatalf("%+v: expected 200 got %d", opt, w.Code)
+		}
+
+		tag := w.Header().Get("Etag")
+
+		if tags[tag] {
+			t.Fatalf("%+v: expected a distinct ETag got %q", opt, tag)
+		}
+
+		tags[tag] = true
+	}
+}
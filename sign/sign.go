@@ -0,0 +1,134 @@
+// Copyright (c) 2013 Simon Zimmermann
+//
+// Permission is hereby granted, free of charge, to any person obtaining
+// a copy of this software and associated documentation files (the
+// "Software"), to deal in the Software without restriction, including
+// without limitation the rights to use, copy, modify, merge, publish,
+// distribute, sublicense, and/or sell copies of the Software, and to
+// permit persons to whom the Software is furnished to do so, subject to
+// the following conditions:
+//
+// The above copyright notice and this permission notice shall be
+// included in all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND,
+// EXPRESS OR IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF
+// MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE AND
+// NONINFRINGEMENT. IN NO EVENT SHALL THE AUTHORS OR COPYRIGHT HOLDERS BE
+// LIABLE FOR ANY CLAIM, DAMAGES OR OTHER LIABILITY, WHETHER IN AN ACTION
+// OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF OR IN CONNECTION
+// WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+// Package sign implements HMAC signed imgfilter URLs.
+//
+// The signature covers the URL path and all query parameters except the
+// signature itself. An optional expiry is given as a unix timestamp in the
+// e query parameter.
+//
+// Example
+//
+//	s := sign.New("secret")
+//	u := s.Sign("/thumbnail/100x100/filename.png", nil, time.Now().Add(time.Hour))
+package sign
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// SignatureParam is the query parameter holding the signature.
+	SignatureParam = "s"
+	// ExpiresParam is the query parameter holding the expiry timestamp.
+	ExpiresParam = "e"
+)
+
+var (
+	ErrMissingSignature = errors.New("sign: missing signature")
+	ErrInvalidSignature = errors.New("sign: invalid signature")
+	ErrExpired          = errors.New("sign: url expired")
+)
+
+type Signer struct {
+	key []byte
+}
+
+// New returns a Signer using secret as the HMAC key.
+func New(secret string) *Signer {
+	return &Signer{key: []byte(secret)}
+}
+
+func (s *Signer) mac(path string, query url.Values) []byte {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(path))
+	h.Write([]byte{'\n'})
+	h.Write([]byte(query.Encode()))
+	return h.Sum(nil)
+}
+
+// Sign returns the URL for path and query with a signature attached. path
+// is the unescaped URL path. The URL expires at expires unless it is zero.
+func (s *Signer) Sign(path string, query url.Values, expires time.Time) string {
+	q := make(url.Values, len(query)+2)
+
+	for k, v := range query {
+		if k != SignatureParam {
+			q[k] = v
+		}
+	}
+
+	if !expires.IsZero() {
+		q.Set(ExpiresParam, strconv.FormatInt(expires.Unix(), 10))
+	}
+
+	q.Set(SignatureParam, base64.RawURLEncoding.EncodeToString(s.mac(path, q)))
+	u := &url.URL{Path: path, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// Verify checks the signature and expiry of a request for path with the
+// given query parameters.
+func (s *Signer) Verify(path string, query url.Values) error {
+	sig := query.Get(SignatureParam)
+
+	if sig == "" {
+		return ErrMissingSignature
+	}
+
+	got, err := base64.RawURLEncoding.DecodeString(sig)
+
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	q := make(url.Values, len(query))
+
+	for k, v := range query {
+		if k != SignatureParam {
+			q[k] = v
+		}
+	}
+
+	if !hmac.Equal(got, s.mac(path, q)) {
+		return ErrInvalidSignature
+	}
+
+	if e := q.Get(ExpiresParam); e != "" {
+		expires, err := strconv.ParseInt(e, 10, 64)
+
+		if err != nil {
+			return ErrInvalidSignature
+		}
+
+		if time.Now().Unix() > expires {
+			return ErrExpired
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package sign
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func verify(s *Signer, rawurl string) error {
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		return err
+	}
+
+	return s.Verify(u.Path, u.Query())
+}
+
+func TestSign(t *testing.T) {
+	s := New("secret")
+
+	tests := []struct {
+		path    string
+		query   url.Values
+		expires time.Time
+	}{
+		{"/thumbnail/100x100/filename.png", nil, time.Time{}},
+		{"/resize/50%/filename.png", nil, time.Now().Add(time.Hour)},
+		{"/resize/640x480!/a b.png", url.Values{"fm": {"webp"}}, time.Time{}},
+	}
+
+	for _, x := range tests {
+		u := s.Sign(x.path, x.query, x.expires)
+
+		if err := verify(s, u); err != nil {
+			t.Fatalf("%s: %v", u, err)
+		}
+
+		if err := verify(New("other"), u); err != ErrInvalidSignature {
+			t.Fatalf("%s: expected invalid signature got %v", u, err)
+		}
+	}
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s := New("secret")
+	u := s.Sign("/thumbnail/100x100/filename.png", url.Values{"q": {"80"}}, time.Time{})
+
+	tests := []struct {
+		rawurl string
+		err    error
+	}{
+		{"/thumbnail/100x100/filename.png", ErrMissingSignature},
+		{"/thumbnail/100x101/filename.png?" + mustParse(u).RawQuery, ErrInvalidSignature},
+		{u + "&q=90", ErrInvalidSignature},
+		{s.Sign("/thumbnail/100x100/filename.png", nil, time.Now().Add(-time.Minute)), ErrExpired},
+	}
+
+	for _, x := range tests {
+		if err := verify(s, x.rawurl); err != x.err {
+			t.Fatalf("%s: expected %v got %v", x.rawurl, x.err, err)
+		}
+	}
+}
+
+func mustParse(rawurl string) *url.URL {
+	u, err := url.Parse(rawurl)
+
+	if err != nil {
+		panic(err)
+	}
+
+	return u
+}